@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// boomap is a command-line client for working with MutableMaps.
+package main
+
+import (
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/mattmoor/boo-maps/pkg/sealing"
+)
+
+const usage = `Usage: boomap <command> [flags]
+
+Commands:
+  seal    Encrypt a value from stdin for the valueFrom.sealed field of a MutableMap.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "seal":
+		if err := seal(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "boomap seal: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// seal encrypts stdin against the cluster's public key and writes the
+// sealed value to stdout.
+func seal(args []string) error {
+	fs := flag.NewFlagSet("seal", flag.ExitOnError)
+	kubeconfig := fs.String("kubeconfig", "", "Path to a kubeconfig. Defaults to the usual kubectl configuration.")
+	context := fs.String("context", "", "The kubeconfig context to use.")
+	namespace := fs.String("namespace", "", "The namespace of the MutableMap in which the value will be used (required).")
+	name := fs.String("name", "", "The name of the MutableMap in which the value will be used (required unless -scope=namespace).")
+	key := fs.String("key", "", "The key of the MutableMap's valueFrom that will hold the value (required unless -scope=namespace).")
+	scope := fs.String("scope", "key", `Where the value may be used: "key" for only the given key of the MutableMap, or "namespace" for any MutableMap in the namespace.`)
+	systemNamespace := fs.String("system-namespace", "boomap-system", "The namespace in which the controller publishes its public key.")
+	publicKey := fs.String("public-key", "", "Path to a PEM encoded public key to use instead of fetching it from the cluster.")
+	fs.Parse(args)
+
+	if *namespace == "" {
+		return fmt.Errorf("-namespace is required")
+	}
+	s := sealing.Scope{Namespace: *namespace}
+	switch *scope {
+	case "key":
+		if *name == "" || *key == "" {
+			return fmt.Errorf("-name and -key are required unless -scope=namespace")
+		}
+		s.MutableMap, s.Key = *name, *key
+	case "namespace":
+		if *name != "" || *key != "" {
+			return fmt.Errorf("-name and -key may not be passed with -scope=namespace")
+		}
+	default:
+		return fmt.Errorf(`-scope must be "key" or "namespace", got %q`, *scope)
+	}
+
+	var pub *rsa.PublicKey
+	var err error
+	if *publicKey != "" {
+		b, err := ioutil.ReadFile(*publicKey)
+		if err != nil {
+			return err
+		}
+		if pub, err = sealing.ParsePublicKey(b); err != nil {
+			return err
+		}
+	} else if pub, err = fetchPublicKey(*kubeconfig, *context, *systemNamespace); err != nil {
+		return err
+	}
+
+	value, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealing.Seal(pub, s, value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(sealed)
+	return nil
+}
+
+// fetchPublicKey fetches the public key that the controller publishes in
+// the system namespace.
+func fetchPublicKey(kubeconfig, context, namespace string) (*rsa.PublicKey, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(sealing.KeyName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the public key: %v", err)
+	}
+	return sealing.PublicKey(cm)
+}
@@ -19,34 +19,67 @@ package main
 import (
 	"context"
 	"flag"
+	"strings"
 	"time"
 
 	"github.com/knative/pkg/configmap"
 	"github.com/knative/pkg/controller"
 	"github.com/knative/pkg/logging"
+	"github.com/knative/pkg/metrics"
 	"github.com/knative/pkg/signals"
 	"github.com/knative/pkg/system"
 	"github.com/knative/serving/pkg/reconciler"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	clientset "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
 	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions"
+	boosinformers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/consumers"
+	"github.com/mattmoor/boo-maps/pkg/integrity"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/immutable"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable"
+	"github.com/mattmoor/boo-maps/pkg/sealing"
 )
 
 const (
 	threadsPerController = 2
+	component            = "controller"
+	metricsDomain        = "boos.mattmoor.io"
+	observabilityConfig  = "config-observability"
 )
 
 var (
-	masterURL  = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
-	kubeconfig = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	masterURL        = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	kubeconfig       = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	allowAnnotations = flag.String("allow-annotations", "", "Comma-separated patterns of the only annotations to propagate from MutableMaps to their snapshots and ConfigMaps (default all).")
+	denyAnnotations  = flag.String("deny-annotations", strings.Join(annotations.DefaultDeny, ","), "Comma-separated patterns of annotations not to propagate from MutableMaps to their snapshots and ConfigMaps.")
+	lazy             = flag.Bool("lazy-configmaps", false, "Only materialize the ConfigMaps of snapshots referenced by workloads.")
+	gracePeriod      = flag.Duration("unreferenced-grace-period", time.Hour, "How long the snapshot must go unreferenced before its ConfigMap is removed, with -lazy-configmaps.")
+	reportOnly       = flag.Bool("report-drift-only", false, "Report changes to frozen ConfigMaps via events and metrics without reverting them.")
+	sign             = flag.Bool("sign-snapshots", false, "Sign the digests of snapshots with a key held in the system namespace, and verify their signatures.")
 )
 
+// lazyOptions returns the configuration of lazy ConfigMap materialization,
+// or nil when it is not enabled.
+func lazyOptions(cl *consumers.Lister, mapTagInformer boosinformers.MapTagInformer,
+	mutableMapInformer boosinformers.MutableMapInformer) *immutable.Lazy {
+	if !*lazy {
+		return nil
+	}
+	return &immutable.Lazy{
+		Consumers:   cl,
+		MapTags:     mapTagInformer,
+		MutableMaps: mutableMapInformer,
+		GracePeriod: *gracePeriod,
+	}
+}
+
 func main() {
 	flag.Parse()
 
@@ -55,6 +88,11 @@ func main() {
 
 	logger := logging.FromContext(context.TODO()).Named("controller")
 
+	filter, err := annotations.Parse(*allowAnnotations, *denyAnnotations)
+	if err != nil {
+		logger.Fatalf("Error parsing annotation filter: %v", err)
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(*masterURL, *kubeconfig)
 	if err != nil {
 		logger.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -70,6 +108,16 @@ func main() {
 		logger.Fatalf("Error building serving clientset: %v", err)
 	}
 
+	if err := sealing.EnsureKey(kubeClient, system.Namespace()); err != nil {
+		logger.Fatalf("Error ensuring the sealing key: %v", err)
+	}
+	var signingKey *integrity.Key
+	if *sign {
+		if signingKey, err = integrity.EnsureKey(kubeClient, system.Namespace()); err != nil {
+			logger.Fatalf("Error ensuring the signing key: %v", err)
+		}
+	}
+
 	configMapWatcher := configmap.NewInformedWatcher(kubeClient, system.Namespace())
 
 	opt := reconciler.Options{
@@ -81,12 +129,29 @@ func main() {
 	}
 
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, opt.ResyncPeriod)
+	// We only cache the Secrets of our snapshots, rather than every Secret
+	// in the cluster.
+	managedInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, opt.ResyncPeriod,
+		kubeinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = boos.ManagedByLabelKey + "=" + boos.ManagedBy
+		}))
 	boosInformerFactory := informers.NewSharedInformerFactory(boosclient, opt.ResyncPeriod)
 
 	// Our shared index informers.
 	mutableMapInformer := boosInformerFactory.Boos().V1alpha1().MutableMaps()
 	immutableMapInformer := boosInformerFactory.Boos().V1alpha1().ImmutableMaps()
+	mapTagInformer := boosInformerFactory.Boos().V1alpha1().MapTags()
 	configMapInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	secretInformer := managedInformerFactory.Core().V1().Secrets()
+	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	replicaSetInformer := kubeInformerFactory.Apps().V1().ReplicaSets()
+	statefulSetInformer := kubeInformerFactory.Apps().V1().StatefulSets()
+	daemonSetInformer := kubeInformerFactory.Apps().V1().DaemonSets()
+	jobInformer := kubeInformerFactory.Batch().V1().Jobs()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+
+	consumerLister := consumers.New(immutableMapInformer, deploymentInformer, replicaSetInformer,
+		statefulSetInformer, daemonSetInformer, jobInformer, podInformer)
 
 	// Add new controllers here.
 	controllers := []*controller.Impl{
@@ -95,30 +160,55 @@ func main() {
 			boosclient,
 			mutableMapInformer,
 			immutableMapInformer,
+			secretInformer,
+			filter,
+			signingKey.Bytes(),
+			consumerLister,
 		),
 		immutable.NewController(
 			opt,
 			boosclient,
 			immutableMapInformer,
 			configMapInformer,
+			secretInformer,
+			*reportOnly,
+			filter,
+			signingKey,
+			lazyOptions(consumerLister, mapTagInformer, mutableMapInformer),
 		),
 	}
 
+	// Watch the observability config map and dynamically update metrics exporter.
+	configMapWatcher.Watch(observabilityConfig, metrics.UpdateExporterFromConfigMap(metricsDomain, component, logger))
+
 	go boosInformerFactory.Start(stopCh)
 	go kubeInformerFactory.Start(stopCh)
+	go managedInformerFactory.Start(stopCh)
 
 	// Wait for the caches to be synced before starting controllers.
 	logger.Info("Waiting for informer caches to sync")
 	for i, synced := range []cache.InformerSynced{
 		mutableMapInformer.Informer().HasSynced,
 		immutableMapInformer.Informer().HasSynced,
+		mapTagInformer.Informer().HasSynced,
 		configMapInformer.Informer().HasSynced,
+		secretInformer.Informer().HasSynced,
+		deploymentInformer.Informer().HasSynced,
+		replicaSetInformer.Informer().HasSynced,
+		statefulSetInformer.Informer().HasSynced,
+		daemonSetInformer.Informer().HasSynced,
+		jobInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
 	} {
 		if ok := cache.WaitForCacheSync(stopCh, synced); !ok {
 			logger.Fatalf("failed to wait for cache at index %v to sync", i)
 		}
 	}
 
+	if err := configMapWatcher.Start(stopCh); err != nil {
+		logger.Fatalf("failed to start configuration manager: %v", err)
+	}
+
 	// Start all of the controllers.
 	for _, ctrlr := range controllers {
 		go func(ctrlr *controller.Impl) {
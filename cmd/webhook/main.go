@@ -26,19 +26,26 @@ import (
 	"github.com/knative/pkg/signals"
 	"github.com/knative/pkg/system"
 	"github.com/knative/pkg/version"
-	"github.com/knative/pkg/webhook"
+	knativewebhook "github.com/knative/pkg/webhook"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
 	clientset "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
 	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions"
+	"github.com/mattmoor/boo-maps/pkg/consumers"
+	"github.com/mattmoor/boo-maps/pkg/guard"
+	"github.com/mattmoor/boo-maps/pkg/resolver"
+	"github.com/mattmoor/boo-maps/pkg/webhook"
 )
 
 const (
@@ -46,16 +53,58 @@ const (
 )
 
 var (
-	masterURL  = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	kubeconfig = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	masterURL     = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	kubeconfig    = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	failurePolicy = flag.String("failure-policy", string(resolver.Fail), "How to handle failures to resolve MutableMaps, either Fail (deny admission) or Ignore (leave the reference unfrozen).")
+	refPolicy     = flag.String("reference-policy", string(v1alpha1.ReferencePolicyDeny), "How to handle references to keys missing from a snapshot, and edits removing keys still referenced by consumers, either Deny or Warn.")
+	timeout       = flag.Duration("snapshot-timeout", 10*time.Second, "How long admission may wait for the snapshots of new MutableMap generations to be created, across all of a resource's references, before failing to resolve them.")
+	controllerSA  = flag.String("controller-service-account", "boomap-controller", "The name of the controller's ServiceAccount in the system namespace, which may modify frozen ConfigMaps and Secrets.")
+	optIn         = flag.Bool("namespace-opt-in", false, "Only freeze resources in namespaces labeled "+boos.FreezeKey+"="+boos.FreezeEnabled+".")
 )
 
+// systemUsers may modify frozen ConfigMaps and Secrets on behalf of garbage collection
+// and namespace deletion.
+var systemUsers = []string{
+	"system:kube-controller-manager",
+	"system:serviceaccount:kube-system:generic-garbage-collector",
+	"system:serviceaccount:kube-system:namespace-controller",
+}
+
+// namespaceSelector returns the selector for the namespaces in which we
+// freeze resources containing a PodSpec.
+func namespaceSelector() *metav1.LabelSelector {
+	if *optIn {
+		return &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				boos.FreezeKey: boos.FreezeEnabled,
+			},
+		}
+	}
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      boos.FreezeKey,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{boos.FreezeDisabled},
+		}},
+	}
+}
+
 func main() {
 	flag.Parse()
 	logger := logging.FromContext(context.TODO()).Named("controller")
 
 	logger.Info("Starting the Configuration Webhook")
 
+	fp, err := resolver.ParseFailurePolicy(*failurePolicy)
+	if err != nil {
+		logger.Fatalw("Invalid -failure-policy", zap.Error(err))
+	}
+	rp := v1alpha1.ReferencePolicy(*refPolicy)
+	if rp != v1alpha1.ReferencePolicyDeny && rp != v1alpha1.ReferencePolicyWarn {
+		logger.Fatalf("Invalid -reference-policy %q, want %q or %q", rp,
+			v1alpha1.ReferencePolicyDeny, v1alpha1.ReferencePolicyWarn)
+	}
+
 	// Set up signals so we handle the first shutdown signal gracefully.
 	stopCh := signals.SetupSignalHandler()
 
@@ -78,40 +127,88 @@ func main() {
 		logger.Fatalf("Version check failed: %v", err)
 	}
 
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 10*time.Hour)
+	// We only need to observe the ConfigMaps and Secrets materializing our
+	// snapshots.
+	managedInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 10*time.Hour,
+		kubeinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = boos.ManagedByLabelKey + "=" + boos.ManagedBy
+		}))
 	boosInformerFactory := informers.NewSharedInformerFactory(boosclient, 10*time.Hour)
 
 	mutableMapInformer := boosInformerFactory.Boos().V1alpha1().MutableMaps()
+	immutableMapInformer := boosInformerFactory.Boos().V1alpha1().ImmutableMaps()
+	mapTagInformer := boosInformerFactory.Boos().V1alpha1().MapTags()
+	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	replicaSetInformer := kubeInformerFactory.Apps().V1().ReplicaSets()
+	statefulSetInformer := kubeInformerFactory.Apps().V1().StatefulSets()
+	daemonSetInformer := kubeInformerFactory.Apps().V1().DaemonSets()
+	jobInformer := kubeInformerFactory.Batch().V1().Jobs()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	configMapInformer := managedInformerFactory.Core().V1().ConfigMaps()
+	secretInformer := managedInformerFactory.Core().V1().Secrets()
 
 	go mutableMapInformer.Informer().Run(stopCh)
+	go immutableMapInformer.Informer().Run(stopCh)
+	go mapTagInformer.Informer().Run(stopCh)
+	go deploymentInformer.Informer().Run(stopCh)
+	go replicaSetInformer.Informer().Run(stopCh)
+	go statefulSetInformer.Informer().Run(stopCh)
+	go daemonSetInformer.Informer().Run(stopCh)
+	go jobInformer.Informer().Run(stopCh)
+	go podInformer.Informer().Run(stopCh)
+	go configMapInformer.Informer().Run(stopCh)
+	go secretInformer.Informer().Run(stopCh)
 
 	// Wait for the caches to be synced before starting controllers.
 	logger.Info("Waiting for informer caches to sync")
 	for i, synced := range []cache.InformerSynced{
 		mutableMapInformer.Informer().HasSynced,
+		immutableMapInformer.Informer().HasSynced,
+		mapTagInformer.Informer().HasSynced,
+		deploymentInformer.Informer().HasSynced,
+		replicaSetInformer.Informer().HasSynced,
+		statefulSetInformer.Informer().HasSynced,
+		daemonSetInformer.Informer().HasSynced,
+		jobInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
+		configMapInformer.Informer().HasSynced,
+		secretInformer.Informer().HasSynced,
 	} {
 		if ok := cache.WaitForCacheSync(stopCh, synced); !ok {
 			logger.Fatalf("failed to wait for cache at index %v to sync", i)
 		}
 	}
 
-	ml := mutableMapInformer.Lister()
-
-	v1alpha1.FreezeConfigMap = func(namespace, name string) string {
-		logger.Infof("Asked to freeze: %s", name)
-		mm, err := ml.MutableMaps(namespace).Get(name)
-		if errors.IsNotFound(err) {
-			return name
-		}
-		return fmt.Sprintf("%s-%05d", mm.Name, mm.Generation)
-	}
+	r := resolver.New(boosclient, mutableMapInformer, immutableMapInformer, mapTagInformer, configMapInformer, secretInformer, fp, *timeout)
+	snapshotGuard := guard.New(kubeClient, immutableMapInformer, append(systemUsers,
+		fmt.Sprintf("system:serviceaccount:%s:%s", system.Namespace(), *controllerSA))...)
+	cl := consumers.New(immutableMapInformer, deploymentInformer, replicaSetInformer,
+		statefulSetInformer, daemonSetInformer, jobInformer, podInformer)
 
 	options := webhook.ControllerOptions{
-		ServiceName:    "webhook",
-		DeploymentName: "webhook",
-		Namespace:      system.Namespace(),
-		Port:           443,
-		SecretName:     "webhook-certs",
-		WebhookName:    "webhook.serving.knative.dev",
+		ControllerOptions: knativewebhook.ControllerOptions{
+			ServiceName:    "webhook",
+			DeploymentName: "webhook",
+			Namespace:      system.Namespace(),
+			Port:           443,
+			SecretName:     "webhook-certs",
+			WebhookName:    "webhook.serving.knative.dev",
+		},
+		NamespaceSelector: namespaceSelector(),
+		// Resources labeled to opt out of freezing need not be admitted by
+		// us at all, where the API server supports it.
+		ObjectSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      boos.FreezeKey,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{boos.FreezeDisabled},
+			}},
+		},
+		// Only consult our guard about the snapshots we manage.
+		ValidatorObjectSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{boos.ManagedByLabelKey: boos.ManagedBy},
+		},
 	}
 	controller := webhook.AdmissionController{
 		Client:  kubeClient,
@@ -119,6 +216,7 @@ func main() {
 		Handlers: map[schema.GroupVersionKind]webhook.GenericCRD{
 			v1alpha1.SchemeGroupVersion.WithKind("ImmutableMap"): &v1alpha1.ImmutableMap{},
 			v1alpha1.SchemeGroupVersion.WithKind("MutableMap"):   &v1alpha1.MutableMap{},
+			v1alpha1.SchemeGroupVersion.WithKind("MapTag"):       &v1alpha1.MapTag{},
 			appsv1.SchemeGroupVersion.WithKind("Deployment"):     &v1alpha1.WithPod{},
 			appsv1.SchemeGroupVersion.WithKind("ReplicaSet"):     &v1alpha1.WithPod{},
 			appsv1.SchemeGroupVersion.WithKind("StatefulSet"):    &v1alpha1.WithPod{},
@@ -130,7 +228,17 @@ func main() {
 				Kind:    "Service",
 			}: &v1alpha1.WithPod{},
 		},
-		Logger: logger,
+		Validators: map[schema.GroupVersionKind]webhook.ResourceValidator{
+			corev1.SchemeGroupVersion.WithKind("ConfigMap"): snapshotGuard,
+			corev1.SchemeGroupVersion.WithKind("Secret"):    snapshotGuard,
+		},
+		Logger:  logger,
+		Timeout: *timeout,
+		WithContext: func(ctx context.Context) context.Context {
+			ctx = v1alpha1.WithResolver(ctx, r)
+			ctx = v1alpha1.WithConsumerLister(ctx, cl)
+			return v1alpha1.WithReferencePolicy(ctx, rp)
+		},
 	}
 	if err = controller.Run(stopCh); err != nil {
 		logger.Fatalw("Failed to start the admission controller", zap.Error(err))
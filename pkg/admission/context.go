@@ -0,0 +1,71 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admission holds the state of the admission request in progress,
+// which our webhook records on the context with which it calls the
+// handlers of our types.  It has no dependencies, so that our API types may
+// consult it without pulling in the webhook itself.
+package admission
+
+import (
+	"context"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun notes on the context that the admission request in progress
+// is a dry run.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, struct{}{})
+}
+
+// IsDryRun returns whether the admission request in progress is a dry run,
+// whose effects will not be persisted.
+func IsDryRun(ctx context.Context) bool {
+	return ctx.Value(dryRunKey{}) != nil
+}
+
+type baselineKey struct{}
+
+// WithinUpdate notes on the context that the admission request in progress
+// is an update of the provided base object.
+func WithinUpdate(ctx context.Context, base interface{}) context.Context {
+	return context.WithValue(ctx, baselineKey{}, base)
+}
+
+// GetBaseline returns the object being updated by the admission request in
+// progress, or nil when it is not an update.
+func GetBaseline(ctx context.Context) interface{} {
+	return ctx.Value(baselineKey{})
+}
+
+type auditKey struct{}
+
+// WithAuditAnnotations attaches a map to the context in which handlers may
+// record audit annotations for the admission request in progress.
+func WithAuditAnnotations(ctx context.Context) (context.Context, map[string]string) {
+	annotations := make(map[string]string)
+	return context.WithValue(ctx, auditKey{}, annotations), annotations
+}
+
+// AddAuditAnnotation records an annotation on the audit log entry for the
+// admission request in progress.  The API server prefixes the key with the
+// name of our webhook.
+func AddAuditAnnotation(ctx context.Context, key, value string) {
+	if annotations, ok := ctx.Value(auditKey{}).(map[string]string); ok {
+		annotations[key] = value
+	}
+}
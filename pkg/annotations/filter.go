@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package annotations decides which annotations propagate from a
+// MutableMap to its snapshots.
+package annotations
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+)
+
+// DefaultDeny are the bookkeeping annotations of kubectl, Knative and our
+// own webhook, which are of no use on snapshots and may be large.
+var DefaultDeny = []string{
+	"kubectl.kubernetes.io/*",
+	boos.CreatorAnnotation,
+	boos.UpdaterAnnotation,
+	boos.UpdateTimeAnnotation,
+	"serving.knative.dev/creator",
+	"serving.knative.dev/lastModifier",
+}
+
+// Filter selects the annotations to propagate by their keys.  Patterns
+// are matched with path.Match, so "example.com/*" matches every annotation
+// with the prefix "example.com/".
+type Filter struct {
+	// Allow, when non-empty, lists the patterns of the only annotations
+	// to propagate.
+	Allow []string
+
+	// Deny lists the patterns of annotations not to propagate, even when
+	// they are allowed.
+	Deny []string
+}
+
+// Default is the Filter applied when none is configured.
+var Default = Filter{Deny: DefaultDeny}
+
+// Parse parses comma-separated lists of patterns into a Filter.
+func Parse(allow, deny string) (Filter, error) {
+	f := Filter{
+		Allow: split(allow),
+		Deny:  split(deny),
+	}
+	for _, patterns := range [][]string{f.Allow, f.Deny} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Filter{}, fmt.Errorf("invalid annotation pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+func split(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Apply returns the annotations that pass the filter.
+func (f Filter) Apply(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if f.allows(k) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func (f Filter) allows(key string) bool {
+	if len(f.Allow) != 0 && !matches(f.Allow, key) {
+		return false
+	}
+	return !matches(f.Deny, key)
+}
+
+func matches(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -18,4 +18,106 @@ package boos
 
 const (
 	GroupName = "boos.mattmoor.io"
+
+	// FreezeKey is the label (on Namespaces) or annotation (on resources
+	// containing a PodSpec) that controls whether the webhook freezes
+	// references to MutableMaps.  On resources, its value is either
+	// FreezeDisabled or a comma-separated list of the MutableMaps to freeze.
+	FreezeKey = GroupName + "/freeze"
+
+	// FreezeEnabled is the value of FreezeKey that opts a Namespace in to
+	// freezing when the webhook is configured to require opt-in.
+	FreezeEnabled = "enabled"
+
+	// FreezeDisabled is the value of FreezeKey that opts a Namespace or
+	// resource out of freezing.
+	FreezeDisabled = "disabled"
+
+	// MutableMapLabelKey is the label on ImmutableMaps and their ConfigMaps
+	// identifying the MutableMap of which they are a snapshot.  Its value is
+	// the name of the MutableMap, unless that is too long for a label, see
+	// v1alpha1.MutableMapLabel.
+	MutableMapLabelKey = GroupName + "/mutableMap"
+
+	// MutableMapAnnotationKey is the annotation on ImmutableMaps naming the
+	// MutableMap of which they are a snapshot.
+	MutableMapAnnotationKey = GroupName + "/mutableMap"
+
+	// GenerationLabelKey is the label on ImmutableMaps and their ConfigMaps
+	// recording the generation of the MutableMap that they snapshot.
+	GenerationLabelKey = GroupName + "/generation"
+
+	// ManagedByLabelKey is the well-known label naming the tool that manages
+	// a resource, which we set to ManagedBy on the resources we create.
+	ManagedByLabelKey = "app.kubernetes.io/managed-by"
+
+	// ManagedBy is the value of ManagedByLabelKey on the resources we create.
+	ManagedBy = "boo-maps"
+
+	// DeletionPolicyKey is the annotation on MutableMaps selecting what
+	// happens to their snapshots when they are deleted.
+	DeletionPolicyKey = GroupName + "/deletionPolicy"
+
+	// PinnedKey is the annotation on which the webhook records the
+	// snapshots to which a resource's ConfigMap references are pinned.
+	PinnedKey = GroupName + "/pinned"
+
+	// PinnedChangeCauseKey is the annotation on which the webhook records
+	// the change-cause it last wrote on a resource, so that it only ever
+	// replaces or clears its own.
+	PinnedChangeCauseKey = GroupName + "/pinnedChangeCause"
+
+	// CreatorAnnotation is the annotation on which the webhook records the
+	// user that created a MutableMap.
+	CreatorAnnotation = GroupName + "/creator"
+
+	// UpdaterAnnotation is the annotation on which the webhook records the
+	// user that last changed the content of a MutableMap.
+	UpdaterAnnotation = GroupName + "/lastModifier"
+
+	// UpdateTimeAnnotation is the annotation on which the webhook records
+	// when the content of a MutableMap last changed, in RFC 3339 format.
+	UpdateTimeAnnotation = GroupName + "/lastModified"
+
+	// ChangedByAnnotation is the annotation on ImmutableMaps recording the
+	// user that made the change of which they are a snapshot.
+	ChangedByAnnotation = GroupName + "/changedBy"
+
+	// ChangedAtAnnotation is the annotation on ImmutableMaps recording when
+	// the change of which they are a snapshot was made.
+	ChangedAtAnnotation = GroupName + "/changedAt"
+
+	// SourceUIDAnnotation is the annotation on ImmutableMaps recording the
+	// UID of the MutableMap of which they are a snapshot.
+	SourceUIDAnnotation = GroupName + "/sourceUID"
+
+	// SourceResourceVersionAnnotation is the annotation on ImmutableMaps
+	// recording the resourceVersion of the MutableMap that they snapshot.
+	SourceResourceVersionAnnotation = GroupName + "/sourceResourceVersion"
+
+	// SecretKeysAnnotation is the annotation on ImmutableMaps listing the
+	// keys of the Secret that holds the values they read from other
+	// resources, when they have one.
+	SecretKeysAnnotation = GroupName + "/secretKeys"
+
+	// ChangeCauseAnnotation is the well-known annotation describing the
+	// cause of a change, which is recorded on the snapshots of MutableMaps.
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+
+	// DigestAnnotation is the annotation on ImmutableMaps and their
+	// ConfigMaps recording the digest of their content.
+	DigestAnnotation = GroupName + "/digest"
+
+	// SecretDigestAnnotation is the annotation on ImmutableMaps recording
+	// the digest of the data of their Secret, when they have one.
+	SecretDigestAnnotation = GroupName + "/secretDigest"
+
+	// SignatureAnnotation is the annotation on ImmutableMaps and their
+	// ConfigMaps recording the signature of their digests, when the
+	// controller signs snapshots.
+	SignatureAnnotation = GroupName + "/signature"
+
+	// Finalizer is the finalizer with which the controller enforces the
+	// deletion policy of MutableMaps.
+	Finalizer = "mutablemaps." + GroupName
 )
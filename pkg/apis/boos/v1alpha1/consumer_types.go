@@ -0,0 +1,46 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+)
+
+// Consumer describes a resource whose PodSpec references a snapshot of
+// a MutableMap.
+type Consumer struct {
+	// APIVersion is the API version of the consuming resource.
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the kind of the consuming resource.
+	Kind string `json:"kind"`
+
+	// Name is the name of the consuming resource.
+	Name string `json:"name"`
+
+	// Snapshot is the name of the ImmutableMap referenced by the resource.
+	Snapshot string `json:"snapshot"`
+
+	// Keys are the keys of the snapshot that the resource requires.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+}
+
+// String returns a human readable description of the consumer.
+func (c *Consumer) String() string {
+	return fmt.Sprintf("%s/%s (%s)", c.Kind, c.Name, c.Snapshot)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+)
+
+// ConsumerLister lists the resources consuming the snapshots of a MutableMap.
+type ConsumerLister interface {
+	// ListConsumers returns the resources whose PodSpec references one of
+	// the snapshots of the provided MutableMap.
+	ListConsumers(mm *MutableMap) ([]Consumer, error)
+}
+
+// none is the ConsumerLister used when none has been attached to the
+// context, it finds no consumers.
+type none struct{}
+
+func (none) ListConsumers(mm *MutableMap) ([]Consumer, error) {
+	return nil, nil
+}
+
+type consumerListerKey struct{}
+
+// WithConsumerLister attaches the provided ConsumerLister to the context
+// for use in validating changes to MutableMaps.
+func WithConsumerLister(ctx context.Context, cl ConsumerLister) context.Context {
+	return context.WithValue(ctx, consumerListerKey{}, cl)
+}
+
+// GetConsumerLister returns the ConsumerLister attached to the context.
+func GetConsumerLister(ctx context.Context) ConsumerLister {
+	if cl, ok := ctx.Value(consumerListerKey{}).(ConsumerLister); ok {
+		return cl
+	}
+	return none{}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+)
+
+const (
+	// ImmutableMapConditionReady is set when the ImmutableMap is verified.
+	ImmutableMapConditionReady = duckv1alpha1.ConditionReady
+
+	// ImmutableMapConditionVerified is set when the content of the
+	// ImmutableMap matches its digest, and the digest its signature.
+	ImmutableMapConditionVerified duckv1alpha1.ConditionType = "Verified"
+)
+
+var immutableCondSet = duckv1alpha1.NewLivingConditionSet(ImmutableMapConditionVerified)
+
+// GetCondition returns the condition of the given type, if any.
+func (ims *ImmutableMapStatus) GetCondition(t duckv1alpha1.ConditionType) *duckv1alpha1.Condition {
+	return immutableCondSet.Manage(ims).GetCondition(t)
+}
+
+// InitializeConditions sets the conditions that are not yet set to Unknown.
+func (ims *ImmutableMapStatus) InitializeConditions() {
+	immutableCondSet.Manage(ims).InitializeConditions()
+}
+
+// MarkVerified records that the content of the ImmutableMap was verified.
+func (ims *ImmutableMapStatus) MarkVerified() {
+	immutableCondSet.Manage(ims).MarkTrue(ImmutableMapConditionVerified)
+}
+
+// MarkVerificationUnknown records that the content of the ImmutableMap
+// cannot be verified.
+func (ims *ImmutableMapStatus) MarkVerificationUnknown(reason, messageFormat string, messageA ...interface{}) {
+	immutableCondSet.Manage(ims).MarkUnknown(ImmutableMapConditionVerified, reason, messageFormat, messageA...)
+}
+
+// MarkVerificationFailed records that the content of the ImmutableMap does
+// not match its digest, or its digest its signature.
+func (ims *ImmutableMapStatus) MarkVerificationFailed(reason, messageFormat string, messageA ...interface{}) {
+	immutableCondSet.Manage(ims).MarkFalse(ImmutableMapConditionVerified, reason, messageFormat, messageA...)
+}
@@ -17,15 +17,23 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/knative/pkg/apis"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/kmeta"
 	"github.com/knative/pkg/kmp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // ImmutableMap is a specification for a ImmutableMap resource
@@ -34,20 +42,69 @@ type ImmutableMap struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec map[string]string `json:"spec"`
+
+	// +optional
+	Status ImmutableMapStatus `json:"status,omitempty"`
+}
+
+// ImmutableMapStatus communicates the observed state of the ImmutableMap.
+type ImmutableMapStatus struct {
+	// UnreferencedSince is when the controller first observed that no
+	// workload references the snapshot, when it materializes ConfigMaps
+	// lazily.  It is cleared once the snapshot is referenced.
+	// +optional
+	UnreferencedSince *metav1.Time `json:"unreferencedSince,omitempty"`
+
+	// Conditions communicates whether the content of the snapshot matches
+	// its digest.
+	// +optional
+	Conditions duckv1alpha1.Conditions `json:"conditions,omitempty"`
 }
 
 // Check that we can create OwnerReferences to a ImmutableMap.
 var _ kmeta.OwnerRefable = (*ImmutableMap)(nil)
-var _ apis.Validatable = (*ImmutableMap)(nil)
-var _ apis.Defaultable = (*ImmutableMap)(nil)
 var _ apis.Immutable = (*ImmutableMap)(nil)
 
 func (r *ImmutableMap) GetGroupVersionKind() schema.GroupVersionKind {
 	return SchemeGroupVersion.WithKind("ImmutableMap")
 }
 
+// SnapshotGeneration returns the generation of the MutableMap captured by
+// the ImmutableMap, or zero if it is not labeled with one.
+func (im *ImmutableMap) SnapshotGeneration() int64 {
+	gen, err := strconv.ParseInt(im.Labels[boos.GenerationLabelKey], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return gen
+}
+
+// SnapshotOf returns the name of the MutableMap captured by the
+// ImmutableMap, or the empty string if it is not a snapshot.
+func (im *ImmutableMap) SnapshotOf() string {
+	if name, ok := im.Annotations[boos.MutableMapAnnotationKey]; ok {
+		return name
+	}
+	// Snapshots predating the annotation are still controlled by their
+	// MutableMap.
+	if owner := metav1.GetControllerOf(im); owner != nil && owner.Kind == "MutableMap" {
+		return owner.Name
+	}
+	return ""
+}
+
+// SecretKeys returns the keys of the Secret holding the values of the
+// snapshot that are read from other resources, and false if it has none.
+func (im *ImmutableMap) SecretKeys() (sets.String, bool) {
+	keys, ok := im.Annotations[boos.SecretKeysAnnotation]
+	if !ok {
+		return nil, false
+	}
+	return sets.NewString(strings.Split(keys, ",")...), true
+}
+
 // Validate ensures ImmutableMap is properly configured.
-func (rt *ImmutableMap) Validate() *apis.FieldError {
+func (rt *ImmutableMap) Validate(ctx context.Context) *apis.FieldError {
 	return nil
 }
 
@@ -71,11 +128,39 @@ func (current *ImmutableMap) CheckImmutableFields(og apis.Immutable) *apis.Field
 			Details: diff,
 		}
 	}
-	return nil
+
+	// The audit trail of the snapshot may be recorded, but never changed.
+	var errs *apis.FieldError
+	for _, key := range auditAnnotations {
+		if v, ok := original.Annotations[key]; ok && current.Annotations[key] != v {
+			errs = errs.Also(&apis.FieldError{
+				Message: "Immutable fields changed",
+				Paths:   []string{fmt.Sprintf("metadata.annotations[%s]", key)},
+				Details: fmt.Sprintf("%q was %q", key, v),
+			})
+		}
+	}
+	return errs
+}
+
+// auditAnnotations are the annotations recording the change of which an
+// ImmutableMap is a snapshot, and the digests and signature of its content
+// and that of its Secret.
+var auditAnnotations = []string{
+	boos.ChangedByAnnotation,
+	boos.ChangedAtAnnotation,
+	boos.SourceUIDAnnotation,
+	boos.SourceResourceVersionAnnotation,
+	boos.ChangeCauseAnnotation,
+	boos.DigestAnnotation,
+	boos.SecretKeysAnnotation,
+	boos.SecretDigestAnnotation,
+	boos.SignatureAnnotation,
 }
 
 // SetDefaults ensures ImmutableMap is properly configured.
-func (rt *ImmutableMap) SetDefaults() {
+func (rt *ImmutableMap) SetDefaults(ctx context.Context) error {
+	return nil
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
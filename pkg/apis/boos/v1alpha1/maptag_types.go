@@ -0,0 +1,118 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/knative/pkg/apis"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// MapTag is a named, movable pointer to a generation of a MutableMap.  It
+// is named <mutableMap>.<tag>, and workloads reference the snapshot it
+// points to as <mutableMap>@<tag>.
+type MapTag struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MapTagSpec `json:"spec"`
+}
+
+// MapTagSpec holds the generation to which the tag points.
+type MapTagSpec struct {
+	// Generation is the generation of the MutableMap whose snapshot the
+	// tag points to.
+	Generation int64 `json:"generation"`
+}
+
+func (r *MapTag) GetGroupVersionKind() schema.GroupVersionKind {
+	return SchemeGroupVersion.WithKind("MapTag")
+}
+
+// TagName returns the name of the MapTag for the tag of the MutableMap.
+func TagName(mutableMap, tag string) string {
+	return mutableMap + "." + tag
+}
+
+// MutableMap returns the name of the MutableMap that the tag points into.
+func (mt *MapTag) MutableMap() string {
+	if i := strings.LastIndex(mt.Name, "."); i > 0 {
+		return mt.Name[:i]
+	}
+	return ""
+}
+
+// ParseTagReference splits a reference of the form <mutableMap>@<tag>,
+// returning false for references that name no tag.  Tags never contain a
+// ".", as MapTags are named after the MutableMap and tag joined by one.
+func ParseTagReference(ref string) (mutableMap, tag string, ok bool) {
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[1], ".") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// Validate ensures MapTag is properly configured.
+func (mt *MapTag) Validate(ctx context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+	// The tag follows the last ".", so that it never contains one.
+	if i := strings.LastIndex(mt.Name, "."); i <= 0 || i == len(mt.Name)-1 {
+		errs = errs.Also(&apis.FieldError{
+			Message: "MapTags must be named <mutableMap>.<tag>",
+			Paths:   []string{"metadata.name"},
+		})
+	}
+	if mt.Spec.Generation < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprint(mt.Spec.Generation), "spec.generation"))
+	}
+	if errs != nil {
+		return errs
+	}
+	// Only tag generations that workloads can be pinned to.
+	if _, err := GetResolver(ctx).GenerationSnapshot(ctx, mt.Namespace, mt.MutableMap(), mt.Spec.Generation); err != nil {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Generation %d of MutableMap %q has no snapshot", mt.Spec.Generation, mt.MutableMap()),
+			Paths:   []string{"spec.generation"},
+			Details: err.Error(),
+		}
+	}
+	return nil
+}
+
+// SetDefaults ensures MapTag is properly configured.
+func (mt *MapTag) SetDefaults(ctx context.Context) error {
+	return nil
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// MapTagList is a list of MapTag resources
+type MapTagList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []MapTag `json:"items"`
+}
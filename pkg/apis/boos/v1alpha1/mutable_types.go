@@ -17,14 +17,29 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/knative/pkg/apis"
 	"github.com/knative/pkg/kmeta"
+	"github.com/knative/pkg/logging"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+	"github.com/mattmoor/boo-maps/pkg/sealing"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // MutableMap is a specification for a MutableMap resource
@@ -33,24 +48,384 @@ type MutableMap struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec map[string]string `json:"spec"`
+
+	// Render declares additional keys of the snapshots of this map, which
+	// are rendered from the keys of Spec in the format to which each is
+	// mapped.
+	// +optional
+	Render map[string]RenderFormat `json:"render,omitempty"`
+
+	// ValueFrom declares additional keys of the snapshots of this map,
+	// whose values are read from their source when the snapshot is taken.
+	// These keys are materialized in a Secret alongside the ConfigMap.
+	// +optional
+	ValueFrom map[string]ValueSource `json:"valueFrom,omitempty"`
+
+	// +optional
+	Status MutableMapStatus `json:"status,omitempty"`
+}
+
+// ValueSource is the source of the value of a key of a MutableMap.  Only
+// one of its fields may be set.
+type ValueSource struct {
+	// SecretKeyRef selects a key of a Secret in the MutableMap's namespace.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// Sealed is a value encrypted against the cluster's public key for
+	// this key of the MutableMap, or for any MutableMap in its namespace
+	// (see `boomap seal`), which only the controller may decrypt.
+	// +optional
+	Sealed string `json:"sealed,omitempty"`
+}
+
+// MutableMapStatus communicates the observed state of the MutableMap.
+type MutableMapStatus struct {
+	// Consumers lists the workloads pinned to snapshots of the MutableMap,
+	// and how far each lags behind its latest generation.
+	// +optional
+	Consumers []ConsumerStatus `json:"consumers,omitempty"`
+}
+
+// ConsumerStatus reports how far a workload pinned to a snapshot of a
+// MutableMap lags behind the MutableMap.
+type ConsumerStatus struct {
+	// APIVersion is the API version of the consuming resource.
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the kind of the consuming resource.
+	Kind string `json:"kind"`
+
+	// Name is the name of the consuming resource.
+	Name string `json:"name"`
+
+	// Snapshot is the name of the ImmutableMap referenced by the resource.
+	Snapshot string `json:"snapshot"`
+
+	// Generation is the generation of the MutableMap captured by Snapshot.
+	Generation int64 `json:"generation"`
+
+	// GenerationsBehind is how many generations Snapshot lags behind the
+	// MutableMap.
+	GenerationsBehind int64 `json:"generationsBehind"`
+
+	// StaleSince is when Snapshot was superseded by the snapshot of a newer
+	// generation, or unset while it is current.
+	// +optional
+	StaleSince *metav1.Time `json:"staleSince,omitempty"`
+}
+
+// RenderFormat is a file format in which the keys of a MutableMap may be
+// rendered.
+type RenderFormat string
+
+const (
+	// RenderJSON renders the keys as a JSON object.
+	RenderJSON RenderFormat = "json"
+
+	// RenderYAML renders the keys as a YAML mapping.
+	RenderYAML RenderFormat = "yaml"
+
+	// RenderProperties renders the keys as a Java properties file.
+	RenderProperties RenderFormat = "properties"
+
+	// RenderDotenv renders the keys as a .env file.
+	RenderDotenv RenderFormat = "dotenv"
+)
+
+// DeletionPolicy determines what happens to the snapshots of a MutableMap
+// when it is deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyCascade deletes the snapshots along with the MutableMap.
+	DeletionPolicyCascade DeletionPolicy = "Cascade"
+
+	// DeletionPolicyOrphan leaves the snapshots behind.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+
+	// DeletionPolicyBlock defers deletion of the MutableMap (and with it its
+	// snapshots) until none of its snapshots have consumers.
+	DeletionPolicyBlock DeletionPolicy = "Block"
+)
+
+// GetDeletionPolicy returns the DeletionPolicy selected by the annotations
+// of the MutableMap, which defaults to DeletionPolicyBlock.
+func (rt *MutableMap) GetDeletionPolicy() DeletionPolicy {
+	if p, ok := rt.Annotations[boos.DeletionPolicyKey]; ok {
+		return DeletionPolicy(p)
+	}
+	return DeletionPolicyBlock
+}
+
+// MutableMapLabel returns the value of the MutableMapLabelKey label on the
+// snapshots of the named MutableMap.  Names may be longer than label values,
+// so those are replaced by a hash of the name.
+func MutableMapLabel(name string) string {
+	if len(name) <= validation.LabelValueMaxLength {
+		return name
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:32]
 }
 
 // Check that we can create OwnerReferences to a MutableMap.
 var _ kmeta.OwnerRefable = (*MutableMap)(nil)
-var _ apis.Validatable = (*MutableMap)(nil)
-var _ apis.Defaultable = (*MutableMap)(nil)
+var _ apis.Annotatable = (*MutableMap)(nil)
 
 func (r *MutableMap) GetGroupVersionKind() schema.GroupVersionKind {
 	return SchemeGroupVersion.WithKind("MutableMap")
 }
 
 // Validate ensures MutableMap is properly configured.
-func (rt *MutableMap) Validate() *apis.FieldError {
-	return nil
+func (rt *MutableMap) Validate(ctx context.Context) *apis.FieldError {
+	switch rt.GetDeletionPolicy() {
+	case DeletionPolicyCascade, DeletionPolicyOrphan, DeletionPolicyBlock:
+	default:
+		return apis.ErrInvalidValue(string(rt.GetDeletionPolicy()),
+			fmt.Sprintf("metadata.annotations[%s]", boos.DeletionPolicyKey))
+	}
+
+	if errs := rt.validateRender().Also(rt.validateValueFrom()).Also(rt.validateSize()); errs != nil {
+		return errs
+	}
+
+	errs := rt.validateConsumers(ctx)
+	if errs != nil && GetReferencePolicy(ctx) == ReferencePolicyWarn {
+		logging.FromContext(ctx).Warnf("Admitting keys removed from under consumers: %v", errs)
+		admission.AddAuditAnnotation(ctx, "broken-consumers", errs.Error())
+		return nil
+	}
+	return errs
+}
+
+// validateRender checks the keys to be rendered into the snapshots.
+func (rt *MutableMap) validateRender() (errs *apis.FieldError) {
+	for key, format := range rt.Render {
+		switch format {
+		case RenderJSON, RenderYAML, RenderProperties, RenderDotenv:
+		default:
+			errs = errs.Also(apis.ErrInvalidValue(string(format), apis.CurrentField).ViaFieldKey("render", key))
+		}
+		if msgs := validation.IsConfigMapKey(key); len(msgs) != 0 {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, apis.CurrentField, msgs...).ViaFieldKey("render", key))
+		}
+		if _, ok := rt.Spec[key]; ok {
+			errs = errs.Also((&apis.FieldError{
+				Message: fmt.Sprintf("Rendered key %q collides with a key of spec", key),
+				Paths:   []string{apis.CurrentField},
+			}).ViaFieldKey("render", key))
+		}
+		if format == RenderDotenv {
+			// Every key becomes the name of an environment variable.
+			for k := range rt.Spec {
+				if msgs := validation.IsCIdentifier(k); len(msgs) != 0 {
+					errs = errs.Also((&apis.FieldError{
+						Message: fmt.Sprintf("Key %q cannot be rendered into dotenv key %q", k, key),
+						Paths:   []string{apis.CurrentField},
+						Details: strings.Join(msgs, "; "),
+					}).ViaFieldKey("spec", k))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateSize checks that every key fits in a shard of the snapshots,
+// since a key is never split across ConfigMaps.
+func (rt *MutableMap) validateSize() (errs *apis.FieldError) {
+	for _, key := range OversizedKeys(rt.Spec) {
+		errs = errs.Also((&apis.FieldError{
+			Message: fmt.Sprintf("Key %q exceeds the %d bytes that a ConfigMap can hold", key, MaxShardSize),
+			Paths:   []string{apis.CurrentField},
+		}).ViaFieldKey("spec", key))
+	}
+	if len(rt.Render) == 0 {
+		return errs
+	}
+	// Rendered keys hold every key of spec, so they are at least as
+	// large as all of spec.
+	size := 0
+	for k, v := range rt.Spec {
+		size += len(k) + len(v)
+	}
+	if size <= MaxShardSize {
+		return errs
+	}
+	for key := range rt.Render {
+		errs = errs.Also((&apis.FieldError{
+			Message: fmt.Sprintf("Rendered key %q exceeds the %d bytes that a ConfigMap can hold", key, MaxShardSize),
+			Paths:   []string{apis.CurrentField},
+		}).ViaFieldKey("render", key))
+	}
+	return errs
+}
+
+// validateValueFrom checks the keys to be read from other resources into
+// the snapshots.
+func (rt *MutableMap) validateValueFrom() (errs *apis.FieldError) {
+	for key, source := range rt.ValueFrom {
+		if msgs := validation.IsConfigMapKey(key); len(msgs) != 0 {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, apis.CurrentField, msgs...).ViaFieldKey("valueFrom", key))
+		}
+		_, inSpec := rt.Spec[key]
+		_, inRender := rt.Render[key]
+		if inSpec || inRender {
+			errs = errs.Also((&apis.FieldError{
+				Message: fmt.Sprintf("Key %q collides with a key of spec or render", key),
+				Paths:   []string{apis.CurrentField},
+			}).ViaFieldKey("valueFrom", key))
+		}
+		errs = errs.Also(source.validate().ViaFieldKey("valueFrom", key))
+	}
+	return errs
+}
+
+// validate checks that exactly one source is well-formed, without reading
+// from it.
+func (vs *ValueSource) validate() *apis.FieldError {
+	switch {
+	case vs.SecretKeyRef != nil && vs.Sealed != "":
+		return apis.ErrMultipleOneOf("secretKeyRef", "sealed")
+	case vs.SecretKeyRef != nil:
+		var errs *apis.FieldError
+		if vs.SecretKeyRef.Name == "" {
+			errs = errs.Also(apis.ErrMissingField("name"))
+		}
+		if vs.SecretKeyRef.Key == "" {
+			errs = errs.Also(apis.ErrMissingField("key"))
+		}
+		return errs.ViaField("secretKeyRef")
+	case vs.Sealed != "":
+		if err := sealing.Validate(vs.Sealed); err != nil {
+			return &apis.FieldError{
+				Message: "Invalid sealed value",
+				Paths:   []string{"sealed"},
+				Details: err.Error(),
+			}
+		}
+		return nil
+	default:
+		return apis.ErrMissingOneOf("secretKeyRef", "sealed")
+	}
+}
+
+// validateConsumers checks that the keys this change removes from the
+// MutableMap are not required by the consumers of its snapshots, so that
+// re-pinning them to the next snapshot does not break them.  Keys that were
+// already missing are not this change's doing, so we do not hold them
+// against it.
+func (rt *MutableMap) validateConsumers(ctx context.Context) *apis.FieldError {
+	base, ok := admission.GetBaseline(ctx).(*MutableMap)
+	if !ok || base == nil {
+		// New MutableMaps have no consumers.
+		return nil
+	}
+	removed := sets.NewString()
+	for _, key := range base.keys() {
+		if !rt.hasKey(key) {
+			removed.Insert(key)
+		}
+	}
+	if removed.Len() == 0 {
+		return nil
+	}
+
+	consumers, err := GetConsumerLister(ctx).ListConsumers(rt)
+	if err != nil {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Unable to list consumers of MutableMap %q", rt.Name),
+			Paths:   []string{apis.CurrentField},
+			Details: err.Error(),
+		}
+	}
+	// Index the consumers by the removed keys they require.
+	requiredBy := make(map[string][]string)
+	for _, c := range consumers {
+		for _, key := range c.Keys {
+			if removed.Has(key) {
+				requiredBy[key] = append(requiredBy[key], c.String())
+			}
+		}
+	}
+
+	var errs *apis.FieldError
+	for _, key := range removed.List() {
+		users, ok := requiredBy[key]
+		if !ok {
+			continue
+		}
+		errs = errs.Also((&apis.FieldError{
+			Message: fmt.Sprintf("Key %q is still required by consumers", key),
+			Paths:   []string{apis.CurrentField},
+			Details: strings.Join(users, ", "),
+		}).ViaFieldKey("spec", key))
+	}
+	return errs
+}
+
+// keys returns the keys of the MutableMap's snapshots.
+func (rt *MutableMap) keys() []string {
+	keys := make([]string, 0, len(rt.Spec)+len(rt.Render)+len(rt.ValueFrom))
+	for key := range rt.Spec {
+		keys = append(keys, key)
+	}
+	for key := range rt.Render {
+		keys = append(keys, key)
+	}
+	for key := range rt.ValueFrom {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// hasKey returns whether the MutableMap's snapshots hold the key.
+func (rt *MutableMap) hasKey(key string) bool {
+	if _, ok := rt.Spec[key]; ok {
+		return true
+	} else if _, ok := rt.Render[key]; ok {
+		return true
+	}
+	_, ok := rt.ValueFrom[key]
+	return ok
 }
 
 // SetDefaults ensures MutableMap is properly configured.
-func (rt *MutableMap) SetDefaults() {
+func (rt *MutableMap) SetDefaults(ctx context.Context) error {
+	return nil
+}
+
+// AnnotateUserInfo implements apis.Annotatable, recording who created the
+// MutableMap, and who last changed its content and when.
+func (rt *MutableMap) AnnotateUserInfo(previous apis.Annotatable, ui *authenticationv1.UserInfo) {
+	if rt.Annotations == nil {
+		rt.Annotations = make(map[string]string, 3)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	prev, ok := previous.(*MutableMap)
+	if !ok || prev == nil {
+		rt.Annotations[boos.CreatorAnnotation] = ui.Username
+		rt.Annotations[boos.UpdaterAnnotation] = ui.Username
+		rt.Annotations[boos.UpdateTimeAnnotation] = now
+		return
+	}
+
+	// These annotations are ours to set, so carry them over from the
+	// previous version rather than trusting the request.
+	for _, key := range []string{boos.CreatorAnnotation, boos.UpdaterAnnotation, boos.UpdateTimeAnnotation} {
+		if v, ok := prev.Annotations[key]; ok {
+			rt.Annotations[key] = v
+		} else {
+			delete(rt.Annotations, key)
+		}
+	}
+	if equality.Semantic.DeepEqual(prev.Spec, rt.Spec) && equality.Semantic.DeepEqual(prev.Render, rt.Render) &&
+		equality.Semantic.DeepEqual(prev.ValueFrom, rt.ValueFrom) {
+		return
+	}
+	rt.Annotations[boos.UpdaterAnnotation] = ui.Username
+	rt.Annotations[boos.UpdateTimeAnnotation] = now
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+)
+
+// fakeConsumers lists the provided consumers, or fails with err.
+type fakeConsumers struct {
+	consumers []Consumer
+	err       error
+	listed    bool
+}
+
+func (f *fakeConsumers) ListConsumers(mm *MutableMap) ([]Consumer, error) {
+	f.listed = true
+	return f.consumers, f.err
+}
+
+func TestValidateConsumers(t *testing.T) {
+	base := &MutableMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec:       map[string]string{"a": "1", "b": "2"},
+		Render:     map[string]RenderFormat{"config.json": RenderJSON},
+	}
+	withoutB := base.DeepCopy()
+	delete(withoutB.Spec, "b")
+	relabelled := base.DeepCopy()
+	relabelled.Labels = map[string]string{"team": "blue"}
+	withoutRender := base.DeepCopy()
+	withoutRender.Render = nil
+	consumerOf := func(keys ...string) Consumer {
+		return Consumer{Kind: "Deployment", Name: "app", Snapshot: "foo-00001", Keys: keys}
+	}
+
+	tests := []struct {
+		name       string
+		base       *MutableMap
+		mm         *MutableMap
+		lister     *fakeConsumers
+		wantErr    bool
+		wantListed bool
+	}{{
+		name:   "create",
+		mm:     base,
+		lister: &fakeConsumers{consumers: []Consumer{consumerOf("c")}},
+	}, {
+		name:   "unrelated change",
+		base:   base,
+		mm:     relabelled,
+		lister: &fakeConsumers{consumers: []Consumer{consumerOf("c")}},
+	}, {
+		name:   "unrelated change while consumers cannot be listed",
+		base:   base,
+		mm:     relabelled,
+		lister: &fakeConsumers{err: errors.New("boom")},
+	}, {
+		name:       "removes a key no consumer requires",
+		base:       base,
+		mm:         withoutB,
+		lister:     &fakeConsumers{consumers: []Consumer{consumerOf("a")}},
+		wantListed: true,
+	}, {
+		name:       "removes a key that a consumer requires",
+		base:       base,
+		mm:         withoutB,
+		lister:     &fakeConsumers{consumers: []Consumer{consumerOf("a", "b")}},
+		wantErr:    true,
+		wantListed: true,
+	}, {
+		name:       "removes a rendered key that a consumer requires",
+		base:       base,
+		mm:         withoutRender,
+		lister:     &fakeConsumers{consumers: []Consumer{consumerOf("config.json")}},
+		wantErr:    true,
+		wantListed: true,
+	}, {
+		name:   "key already missing before the change",
+		base:   withoutB,
+		mm:     func() *MutableMap { mm := withoutB.DeepCopy(); mm.Labels = relabelled.Labels; return mm }(),
+		lister: &fakeConsumers{consumers: []Consumer{consumerOf("b")}},
+	}, {
+		name:       "removes a key while consumers cannot be listed",
+		base:       base,
+		mm:         withoutB,
+		lister:     &fakeConsumers{err: errors.New("boom")},
+		wantErr:    true,
+		wantListed: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithConsumerLister(context.Background(), test.lister)
+			if test.base != nil {
+				ctx = admission.WithinUpdate(ctx, test.base)
+			}
+			if err := test.mm.validateConsumers(ctx); (err != nil) != test.wantErr {
+				t.Errorf("validateConsumers() = %v, wanted error: %v", err, test.wantErr)
+			}
+			if test.lister.listed != test.wantListed {
+				t.Errorf("listed consumers = %v, wanted %v", test.lister.listed, test.wantListed)
+			}
+		})
+	}
+}
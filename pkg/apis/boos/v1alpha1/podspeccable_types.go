@@ -17,10 +17,20 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/knative/pkg/apis"
 	"github.com/knative/pkg/apis/duck"
+	"github.com/knative/pkg/logging"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 )
 
 // +genclient
@@ -42,48 +52,581 @@ type WithPodSpec struct {
 	Template PodSpeccable `json:"template,omitempty"`
 }
 
-var _ apis.Validatable = (*WithPod)(nil)
-var _ apis.Defaultable = (*WithPod)(nil)
 var _ duck.Populatable = (*WithPod)(nil)
 var _ duck.Implementable = (*PodSpeccable)(nil)
 
 // Validate ensures WithPod is properly configured.
-func (rt *WithPod) Validate() *apis.FieldError {
+func (rt *WithPod) Validate(ctx context.Context) *apis.FieldError {
+	errs := rt.validateReferences(ctx)
+	if errs != nil && GetReferencePolicy(ctx) == ReferencePolicyWarn {
+		logging.FromContext(ctx).Warnf("Admitting broken references: %v", errs)
+		admission.AddAuditAnnotation(ctx, "broken-references", errs.Error())
+		return nil
+	}
+	return errs
+}
+
+// validateReferences checks that the keys referenced by the PodSpec exist
+// in the snapshots to which it has been frozen.
+func (rt *WithPod) validateReferences(ctx context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+	spec := rt.Spec.Template.Spec
+	for i, v := range spec.Volumes {
+		cm := v.VolumeSource.ConfigMap
+		if cm == nil || isOptional(cm.Optional) {
+			continue
+		}
+		for j, item := range cm.Items {
+			errs = errs.Also(rt.validateKey(ctx, cm.Name, item.Key).
+				ViaFieldIndex("items", j).ViaField("configMap").ViaFieldIndex("volumes", i))
+		}
+	}
+	for i, v := range spec.Volumes {
+		s := v.VolumeSource.Secret
+		if s == nil || isOptional(s.Optional) {
+			continue
+		}
+		for j, item := range s.Items {
+			errs = errs.Also(rt.validateSecretKey(ctx, s.SecretName, item.Key).
+				ViaFieldIndex("items", j).ViaField("secret").ViaFieldIndex("volumes", i))
+		}
+	}
+	for i, c := range spec.InitContainers {
+		errs = errs.Also(rt.validateEnv(ctx, c).ViaFieldIndex("initContainers", i))
+	}
+	for i, c := range spec.Containers {
+		errs = errs.Also(rt.validateEnv(ctx, c).ViaFieldIndex("containers", i))
+	}
+	return errs.ViaField("spec", "template", "spec")
+}
+
+// validateEnv checks the ConfigMap and Secret keys referenced by the
+// container's environment variables.
+func (rt *WithPod) validateEnv(ctx context.Context, c corev1.Container) (errs *apis.FieldError) {
+	for i, env := range c.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil && !isOptional(ref.Optional) {
+			errs = errs.Also(rt.validateKey(ctx, ref.Name, ref.Key).
+				ViaField("valueFrom", "configMapKeyRef").ViaFieldIndex("env", i))
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil && !isOptional(ref.Optional) {
+			errs = errs.Also(rt.validateSecretKey(ctx, ref.Name, ref.Key).
+				ViaField("valueFrom", "secretKeyRef").ViaFieldIndex("env", i))
+		}
+	}
+	return errs
+}
+
+// validateKey checks that the named ConfigMap contains the key, when that
+// ConfigMap is a snapshot or one of its shards.
+func (rt *WithPod) validateKey(ctx context.Context, name, key string) *apis.FieldError {
+	name = SnapshotName(name)
+	im, err := GetResolver(ctx).Snapshot(ctx, rt.Namespace, name)
+	if err != nil {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Unable to fetch snapshot %q", name),
+			Paths:   []string{apis.CurrentField},
+			Details: err.Error(),
+		}
+	} else if im == nil {
+		// We only know the contents of snapshots.
+		return nil
+	}
+	if _, ok := im.Spec[key]; !ok {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Key %q does not exist in snapshot %q", key, name),
+			Paths:   []string{"key"},
+		}
+	}
 	return nil
 }
 
-var FreezeConfigMap = func(namespace, name string) string {
-	return name
+// validateSecretKey checks that the named Secret contains the key, when
+// that Secret holds the values of a snapshot.
+func (rt *WithPod) validateSecretKey(ctx context.Context, name, key string) *apis.FieldError {
+	im, err := GetResolver(ctx).Snapshot(ctx, rt.Namespace, name)
+	if err != nil {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Unable to fetch snapshot %q", name),
+			Paths:   []string{apis.CurrentField},
+			Details: err.Error(),
+		}
+	} else if im == nil {
+		// We only know the contents of snapshots.
+		return nil
+	}
+	keys, ok := im.SecretKeys()
+	if !ok {
+		// Snapshots created before we recorded the keys of their Secret.
+		return nil
+	}
+	if !keys.Has(key) {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Key %q does not exist in the Secret of snapshot %q", key, name),
+			Paths:   []string{"key"},
+		}
+	}
+	return nil
+}
+
+func isOptional(b *bool) bool {
+	return b != nil && *b
+}
+
+// freezes returns whether the named ConfigMap reference should be frozen
+// based on the boos.FreezeKey annotation (or label) of this resource.
+// References to a tag of a MutableMap are always frozen, as they name no
+// ConfigMap.
+func (rt *WithPod) freezes(name string) bool {
+	if _, _, ok := ParseTagReference(name); ok {
+		return true
+	}
+	if rt.Labels[boos.FreezeKey] == boos.FreezeDisabled {
+		return false
+	}
+	value, ok := rt.Annotations[boos.FreezeKey]
+	if !ok {
+		return true
+	}
+	if value == boos.FreezeDisabled {
+		return false
+	}
+	for _, allowed := range strings.Split(value, ",") {
+		if strings.TrimSpace(allowed) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// freeze resolves the named ConfigMap reference in place, unless this
+// resource has opted out of freezing it.
+func (rt *WithPod) freeze(ctx context.Context, name *string) *apis.FieldError {
+	return rt.freezeWith(ctx, "ConfigMap", GetResolver(ctx).Resolve, name)
+}
+
+// freezeSecret resolves the named Secret reference in place, unless this
+// resource has opted out of freezing it.
+func (rt *WithPod) freezeSecret(ctx context.Context, name *string) *apis.FieldError {
+	return rt.freezeWith(ctx, "Secret", GetResolver(ctx).ResolveSecret, name)
+}
+
+// freezeWith resolves the named reference to a resource of the given kind
+// in place with the provided function.
+func (rt *WithPod) freezeWith(ctx context.Context, kind string,
+	resolve func(ctx context.Context, namespace, name string) (string, error), name *string) *apis.FieldError {
+	if !rt.freezes(*name) {
+		return nil
+	}
+	frozen, err := resolve(ctx, rt.Namespace, *name)
+	if err != nil {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("Unable to freeze %s %q", kind, *name),
+			Paths:   []string{"name"},
+			Details: err.Error(),
+		}
+	}
+	*name = frozen
+	return nil
+}
+
+// freezeEnv freezes the ConfigMap and Secret references among the
+// container's environment variables.
+func (rt *WithPod) freezeEnv(ctx context.Context, c *corev1.Container) (errs *apis.FieldError) {
+	for idx, env := range c.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if env.ValueFrom.ConfigMapKeyRef != nil {
+			errs = errs.Also(rt.freeze(ctx, &c.Env[idx].ValueFrom.ConfigMapKeyRef.LocalObjectReference.Name).
+				ViaField("valueFrom", "configMapKeyRef").ViaFieldIndex("env", idx))
+		}
+		if env.ValueFrom.SecretKeyRef != nil {
+			errs = errs.Also(rt.freezeSecret(ctx, &c.Env[idx].ValueFrom.SecretKeyRef.LocalObjectReference.Name).
+				ViaField("valueFrom", "secretKeyRef").ViaFieldIndex("env", idx))
+		}
+	}
+	return errs
 }
 
 // SetDefaults ensures WithPod is properly configured.
-func (rt *WithPod) SetDefaults() {
-	for idx, v := range rt.Spec.Template.Spec.Volumes {
+func (rt *WithPod) SetDefaults(ctx context.Context) error {
+	var errs *apis.FieldError
+	spec := &rt.Spec.Template.Spec
+	for idx, v := range spec.Volumes {
 		// TODO(mattmoor): ProjectedVolumeSource
+		if v.VolumeSource.ConfigMap != nil {
+			errs = errs.Also(rt.freeze(ctx, &spec.Volumes[idx].VolumeSource.ConfigMap.LocalObjectReference.Name).
+				ViaField("configMap").ViaFieldIndex("volumes", idx))
+		}
+		if v.VolumeSource.Secret != nil {
+			errs = errs.Also(rt.freezeSecret(ctx, &spec.Volumes[idx].VolumeSource.Secret.SecretName).
+				ViaField("secret").ViaFieldIndex("volumes", idx))
+		}
+	}
+	for idx := range spec.InitContainers {
+		errs = errs.Also(rt.freezeEnv(ctx, &spec.InitContainers[idx]).ViaFieldIndex("initContainers", idx))
+	}
+	for idx := range spec.Containers {
+		errs = errs.Also(rt.freezeEnv(ctx, &spec.Containers[idx]).ViaFieldIndex("containers", idx))
+	}
+	if errs != nil {
+		return errs.ViaField("spec", "template", "spec")
+	}
+	if errs := rt.shard(ctx); errs != nil {
+		return errs.ViaField("spec", "template", "spec")
+	}
+	rt.pin(ctx)
+	return nil
+}
+
+// shard rewrites the references to snapshots whose content is split
+// across several ConfigMaps, so that volumes project the keys of every
+// shard and environment variables reference the shard holding their key.
+func (rt *WithPod) shard(ctx context.Context) (errs *apis.FieldError) {
+	spec := &rt.Spec.Template.Spec
+	for idx, v := range spec.Volumes {
 		if v.VolumeSource.ConfigMap == nil {
 			continue
 		}
-		rt.Spec.Template.Spec.Volumes[idx].VolumeSource.ConfigMap.LocalObjectReference.Name =
-			FreezeConfigMap(rt.Namespace, v.VolumeSource.ConfigMap.LocalObjectReference.Name)
+		// A `kubectl apply` of the original configMap volume merges it into
+		// the projected volume that we previously rewrote it to, leaving
+		// both set.  The configMap is what the user asked for, so drop our
+		// projection of it and project it anew below.
+		if isShardProjection(v.VolumeSource.Projected) {
+			spec.Volumes[idx].VolumeSource.Projected = nil
+		}
+		shards, err := rt.shards(ctx, v.VolumeSource.ConfigMap.Name)
+		if err != nil {
+			errs = errs.Also(err.ViaField("configMap").ViaFieldIndex("volumes", idx))
+		} else if projected := projectShards(v.VolumeSource.ConfigMap, shards); projected != nil {
+			spec.Volumes[idx].VolumeSource = corev1.VolumeSource{Projected: projected}
+		}
 	}
-	for _, c := range rt.Spec.Template.Spec.InitContainers {
-		for idx, env := range c.Env {
-			if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil {
-				continue
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, c := range containers {
+			for idx, env := range c.Env {
+				if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil {
+					continue
+				}
+				ref := c.Env[idx].ValueFrom.ConfigMapKeyRef
+				shards, err := rt.shards(ctx, ref.Name)
+				if err != nil {
+					errs = errs.Also(err.ViaField("valueFrom", "configMapKeyRef").ViaFieldIndex("env", idx))
+					continue
+				}
+				for i, keys := range shards {
+					if keys.Has(ref.Key) {
+						ref.Name = ShardName(ref.Name, i)
+						break
+					}
+				}
 			}
-			c.Env[idx].ValueFrom.ConfigMapKeyRef.LocalObjectReference.Name =
-				FreezeConfigMap(rt.Namespace, env.ValueFrom.ConfigMapKeyRef.LocalObjectReference.Name)
 		}
 	}
-	for _, c := range rt.Spec.Template.Spec.Containers {
-		for idx, env := range c.Env {
-			if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil {
-				continue
+	return errs
+}
+
+// shards returns the keys of each shard of the named snapshot, or nil
+// when it is not a snapshot or is materialized as a single ConfigMap.
+func (rt *WithPod) shards(ctx context.Context, name string) ([]sets.String, *apis.FieldError) {
+	im, err := GetResolver(ctx).Snapshot(ctx, rt.Namespace, name)
+	if err != nil {
+		return nil, &apis.FieldError{
+			Message: fmt.Sprintf("Unable to fetch snapshot %q", name),
+			Paths:   []string{"name"},
+			Details: err.Error(),
+		}
+	} else if im == nil {
+		return nil, nil
+	}
+	shards := im.Shards()
+	if len(shards) < 2 {
+		return nil, nil
+	}
+	keys := make([]sets.String, 0, len(shards))
+	for _, shard := range shards {
+		keys = append(keys, sets.NewString(shard...))
+	}
+	return keys, nil
+}
+
+// projectShards returns a projected volume over the shards of the
+// ConfigMap volume, or nil when it has no shards.  A volume selecting keys
+// that the snapshot lacks is left for validation to report.
+func projectShards(cm *corev1.ConfigMapVolumeSource, shards []sets.String) *corev1.ProjectedVolumeSource {
+	if len(shards) == 0 {
+		return nil
+	}
+	items := make([][]corev1.KeyToPath, len(shards))
+	for _, item := range cm.Items {
+		found := false
+		for i, keys := range shards {
+			if keys.Has(item.Key) {
+				items[i] = append(items[i], item)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	projected := &corev1.ProjectedVolumeSource{DefaultMode: cm.DefaultMode}
+	for i := range shards {
+		if len(cm.Items) > 0 && len(items[i]) == 0 {
+			continue
+		}
+		projected.Sources = append(projected.Sources, corev1.VolumeProjection{
+			ConfigMap: &corev1.ConfigMapProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ShardName(cm.Name, i)},
+				Items:                items[i],
+				Optional:             cm.Optional,
+			},
+		})
+	}
+	return projected
+}
+
+// isShardProjection returns whether the projected volume only projects
+// shards of snapshots, as projectShards produces.
+func isShardProjection(projected *corev1.ProjectedVolumeSource) bool {
+	if projected == nil || len(projected.Sources) == 0 {
+		return false
+	}
+	for _, source := range projected.Sources {
+		if source.ConfigMap == nil || SnapshotName(source.ConfigMap.Name) == source.ConfigMap.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// pin records the snapshots referenced by the PodSpec on the boos.PinnedKey
+// annotation, as a comma-separated list of mutableMap=snapshot pairs, so
+// that `kubectl describe` shows which generation of each MutableMap the
+// resource is pinned to.
+func (rt *WithPod) pin(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	pins := make(map[string]*ImmutableMap)
+	for name := range rt.Spec.Template.SnapshotReferences() {
+		im, err := GetResolver(ctx).Snapshot(ctx, rt.Namespace, name)
+		if err != nil {
+			// The pins are informational, so leave them as they were.
+			logger.Errorf("Unable to record pins: %v", err)
+			return
+		} else if im == nil {
+			continue
+		}
+		if mm := im.SnapshotOf(); mm != "" {
+			pins[mm] = im
+		}
+	}
+	rt.setChangeCause(ctx, pins)
+	if len(pins) == 0 {
+		delete(rt.Annotations, boos.PinnedKey)
+		return
+	}
+	var values []string
+	for _, mm := range sortedKeys(pins) {
+		values = append(values, mm+"="+pins[mm].Name)
+	}
+	if rt.Annotations == nil {
+		rt.Annotations = make(map[string]string, 1)
+	}
+	rt.Annotations[boos.PinnedKey] = strings.Join(values, ",")
+	logger.Infof("Pinned %s/%s to %s", rt.Namespace, rt.Name, rt.Annotations[boos.PinnedKey])
+}
+
+// setChangeCause describes how the pins of the resource change on the
+// well-known change-cause annotation, so that `kubectl rollout history`
+// reflects config rollouts.  We only replace or clear a change-cause that
+// we wrote, leaving alone those provided by the user, even once they are
+// carried over to later changes.
+func (rt *WithPod) setChangeCause(ctx context.Context, pins map[string]*ImmutableMap) {
+	cause, ok := rt.Annotations[boos.ChangeCauseAnnotation]
+	ours := ok && cause == rt.Annotations[boos.PinnedChangeCauseKey]
+	if ok && !ours {
+		delete(rt.Annotations, boos.PinnedChangeCauseKey)
+		return
+	}
+
+	old := make(map[string]string)
+	if base, isUpdate := admission.GetBaseline(ctx).(*WithPod); isUpdate && base != nil {
+		for _, pin := range strings.Split(base.Annotations[boos.PinnedKey], ",") {
+			if parts := strings.SplitN(pin, "=", 2); len(parts) == 2 {
+				old[parts[0]] = parts[1]
 			}
-			c.Env[idx].ValueFrom.ConfigMapKeyRef.LocalObjectReference.Name =
-				FreezeConfigMap(rt.Namespace, env.ValueFrom.ConfigMapKeyRef.LocalObjectReference.Name)
 		}
 	}
+
+	var changes []string
+	for _, mm := range sortedKeys(pins) {
+		generation := pins[mm].Labels[boos.GenerationLabelKey]
+		if previous, ok := old[mm]; !ok {
+			changes = append(changes, fmt.Sprintf("pinned %s to generation %s", mm, generation))
+		} else if previous != pins[mm].Name {
+			changes = append(changes, fmt.Sprintf("re-pinned %s from %s to generation %s",
+				mm, rt.describeSnapshot(ctx, previous), generation))
+		}
+	}
+	var unpinned []string
+	for mm := range old {
+		if _, ok := pins[mm]; !ok {
+			unpinned = append(unpinned, mm)
+		}
+	}
+	sort.Strings(unpinned)
+	for _, mm := range unpinned {
+		changes = append(changes, fmt.Sprintf("unpinned %s", mm))
+	}
+	if len(changes) == 0 {
+		// Our description of an earlier change does not describe this one.
+		if ours {
+			delete(rt.Annotations, boos.ChangeCauseAnnotation)
+			delete(rt.Annotations, boos.PinnedChangeCauseKey)
+		}
+		return
+	}
+	if rt.Annotations == nil {
+		rt.Annotations = make(map[string]string, 2)
+	}
+	cause = "Config: " + strings.Join(changes, ", ")
+	rt.Annotations[boos.ChangeCauseAnnotation] = cause
+	rt.Annotations[boos.PinnedChangeCauseKey] = cause
+}
+
+// describeSnapshot describes the named snapshot by its generation, or by
+// its name when that is unknown.
+func (rt *WithPod) describeSnapshot(ctx context.Context, name string) string {
+	im, err := GetResolver(ctx).Snapshot(ctx, rt.Namespace, name)
+	if err != nil || im == nil {
+		return name
+	}
+	if generation, ok := im.Labels[boos.GenerationLabelKey]; ok {
+		return "generation " + generation
+	}
+	return name
+}
+
+func sortedKeys(pins map[string]*ImmutableMap) []string {
+	keys := make([]string, 0, len(pins))
+	for k := range pins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigMapReferences returns the names of the ConfigMaps referenced by
+// the PodSpec, mapped to the keys of each that the PodSpec requires.  A
+// volume mounting a whole ConfigMap references it without requiring keys.
+// References to the shards of a snapshot are reported as references to
+// the snapshot.
+func (ps *PodSpeccable) ConfigMapReferences() map[string]sets.String {
+	refs := make(map[string]sets.String)
+	reference := func(name string) sets.String {
+		name = SnapshotName(name)
+		if _, ok := refs[name]; !ok {
+			refs[name] = sets.NewString()
+		}
+		return refs[name]
+	}
+	referenceItems := func(name string, optional *bool, items []corev1.KeyToPath) {
+		keys := reference(name)
+		if isOptional(optional) {
+			return
+		}
+		for _, item := range items {
+			keys.Insert(item.Key)
+		}
+	}
+	for _, v := range ps.Spec.Volumes {
+		if cm := v.VolumeSource.ConfigMap; cm != nil {
+			referenceItems(cm.Name, cm.Optional, cm.Items)
+		}
+		if v.VolumeSource.Projected == nil {
+			continue
+		}
+		for _, source := range v.VolumeSource.Projected.Sources {
+			if cm := source.ConfigMap; cm != nil {
+				referenceItems(cm.Name, cm.Optional, cm.Items)
+			}
+		}
+	}
+	for _, containers := range [][]corev1.Container{ps.Spec.InitContainers, ps.Spec.Containers} {
+		for _, c := range containers {
+			for _, env := range c.Env {
+				if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil {
+					continue
+				}
+				ref := env.ValueFrom.ConfigMapKeyRef
+				keys := reference(ref.Name)
+				if !isOptional(ref.Optional) {
+					keys.Insert(ref.Key)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// SecretReferences returns the names of the Secrets referenced by the
+// PodSpec, mapped to the keys of each that the PodSpec requires.  A volume
+// mounting a whole Secret references it without requiring keys.
+func (ps *PodSpeccable) SecretReferences() map[string]sets.String {
+	refs := make(map[string]sets.String)
+	reference := func(name string) sets.String {
+		if _, ok := refs[name]; !ok {
+			refs[name] = sets.NewString()
+		}
+		return refs[name]
+	}
+	for _, v := range ps.Spec.Volumes {
+		s := v.VolumeSource.Secret
+		if s == nil {
+			continue
+		}
+		keys := reference(s.SecretName)
+		if isOptional(s.Optional) {
+			continue
+		}
+		for _, item := range s.Items {
+			keys.Insert(item.Key)
+		}
+	}
+	for _, containers := range [][]corev1.Container{ps.Spec.InitContainers, ps.Spec.Containers} {
+		for _, c := range containers {
+			for _, env := range c.Env {
+				if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+					continue
+				}
+				ref := env.ValueFrom.SecretKeyRef
+				keys := reference(ref.Name)
+				if !isOptional(ref.Optional) {
+					keys.Insert(ref.Key)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// SnapshotReferences returns the names of the ConfigMaps and Secrets
+// referenced by the PodSpec, which share the names of the snapshots they
+// were materialized from, mapped to the keys of each that it requires.
+func (ps *PodSpeccable) SnapshotReferences() map[string]sets.String {
+	refs := ps.ConfigMapReferences()
+	for name, keys := range ps.SecretReferences() {
+		if _, ok := refs[name]; ok {
+			refs[name] = refs[name].Union(keys)
+		} else {
+			refs[name] = keys
+		}
+	}
+	return refs
 }
 
 // GetFullType implements duck.Implementable
@@ -0,0 +1,333 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+)
+
+// pinnedTo returns the snapshot of the given generation of foo.
+func pinnedTo(generation int) map[string]*ImmutableMap {
+	return map[string]*ImmutableMap{
+		"foo": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("foo-abcdef12-%05d", generation),
+				Labels: map[string]string{boos.GenerationLabelKey: fmt.Sprint(generation)},
+			},
+		},
+	}
+}
+
+// withPod returns a resource with the provided annotations.
+func withPod(annotations ...string) *WithPod {
+	wp := &WithPod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "app",
+			Annotations: make(map[string]string, len(annotations)/2),
+		},
+	}
+	for i := 0; i+1 < len(annotations); i += 2 {
+		wp.Annotations[annotations[i]] = annotations[i+1]
+	}
+	return wp
+}
+
+func TestSetChangeCause(t *testing.T) {
+	const (
+		pinnedToOne = "foo=foo-abcdef12-00001"
+		ourCause    = "Config: pinned foo to generation 1"
+		userCause   = "Bump the image"
+	)
+
+	tests := []struct {
+		name string
+		base *WithPod
+		wp   *WithPod
+		pins map[string]*ImmutableMap
+		want map[string]string
+	}{{
+		name: "pinned on creation",
+		wp:   withPod(),
+		pins: pinnedTo(1),
+		want: map[string]string{
+			boos.ChangeCauseAnnotation: ourCause,
+			boos.PinnedChangeCauseKey:  ourCause,
+		},
+	}, {
+		name: "created with a change-cause",
+		wp:   withPod(boos.ChangeCauseAnnotation, userCause),
+		pins: pinnedTo(1),
+		want: map[string]string{
+			boos.ChangeCauseAnnotation: userCause,
+		},
+	}, {
+		name: "re-pinned replaces our change-cause",
+		base: withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, ourCause, boos.PinnedChangeCauseKey, ourCause),
+		wp:   withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, ourCause, boos.PinnedChangeCauseKey, ourCause),
+		pins: pinnedTo(2),
+		want: map[string]string{
+			boos.PinnedKey:             pinnedToOne,
+			boos.ChangeCauseAnnotation: "Config: re-pinned foo from foo-abcdef12-00001 to generation 2",
+			boos.PinnedChangeCauseKey:  "Config: re-pinned foo from foo-abcdef12-00001 to generation 2",
+		},
+	}, {
+		name: "re-pinned keeps a change-cause carried over from the user",
+		base: withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, userCause),
+		wp:   withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, userCause),
+		pins: pinnedTo(2),
+		want: map[string]string{
+			boos.PinnedKey:             pinnedToOne,
+			boos.ChangeCauseAnnotation: userCause,
+		},
+	}, {
+		name: "re-pinned along with a change-cause from the user",
+		base: withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, ourCause, boos.PinnedChangeCauseKey, ourCause),
+		wp:   withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, userCause, boos.PinnedChangeCauseKey, ourCause),
+		pins: pinnedTo(2),
+		want: map[string]string{
+			boos.PinnedKey:             pinnedToOne,
+			boos.ChangeCauseAnnotation: userCause,
+		},
+	}, {
+		name: "unrelated change clears our change-cause",
+		base: withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, ourCause, boos.PinnedChangeCauseKey, ourCause),
+		wp:   withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, ourCause, boos.PinnedChangeCauseKey, ourCause),
+		pins: pinnedTo(1),
+		want: map[string]string{
+			boos.PinnedKey: pinnedToOne,
+		},
+	}, {
+		name: "unrelated change keeps a change-cause from the user",
+		base: withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, userCause),
+		wp:   withPod(boos.PinnedKey, pinnedToOne, boos.ChangeCauseAnnotation, userCause),
+		pins: pinnedTo(1),
+		want: map[string]string{
+			boos.PinnedKey:             pinnedToOne,
+			boos.ChangeCauseAnnotation: userCause,
+		},
+	}, {
+		name: "unpinned",
+		base: withPod(boos.PinnedKey, pinnedToOne),
+		wp:   withPod(boos.PinnedKey, pinnedToOne),
+		want: map[string]string{
+			boos.PinnedKey:             pinnedToOne,
+			boos.ChangeCauseAnnotation: "Config: unpinned foo",
+			boos.PinnedChangeCauseKey:  "Config: unpinned foo",
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.base != nil {
+				ctx = admission.WithinUpdate(ctx, test.base)
+			}
+			test.wp.setChangeCause(ctx, test.pins)
+			if diff := cmp.Diff(test.want, test.wp.Annotations); diff != "" {
+				t.Errorf("annotations (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+// fakeSnapshots resolves the names of the provided snapshots to them.
+type fakeSnapshots struct {
+	identity
+	snapshots map[string]*ImmutableMap
+	err       error
+}
+
+func (f *fakeSnapshots) Snapshot(ctx context.Context, namespace, name string) (*ImmutableMap, error) {
+	return f.snapshots[name], f.err
+}
+
+// configMapVolume mounts the named ConfigMap, or the provided keys of it.
+func configMapVolume(name string, keys ...string) corev1.Volume {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Items:                items,
+			},
+		},
+	}
+}
+
+// shardVolume projects the provided keys of each of the named shards, or
+// all of their keys.
+func shardVolume(shards map[string][]string) corev1.Volume {
+	projected := &corev1.ProjectedVolumeSource{}
+	for _, name := range []string{"big-shard-0", "big-shard-1", "big-shard-2"} {
+		keys, ok := shards[name]
+		if !ok {
+			continue
+		}
+		var items []corev1.KeyToPath
+		for _, key := range keys {
+			items = append(items, corev1.KeyToPath{Key: key, Path: key})
+		}
+		projected.Sources = append(projected.Sources, corev1.VolumeProjection{
+			ConfigMap: &corev1.ConfigMapProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Items:                items,
+			},
+		})
+	}
+	return corev1.Volume{Name: "config", VolumeSource: corev1.VolumeSource{Projected: projected}}
+}
+
+// envFrom reads the key of the named ConfigMap into the environment.
+func envFrom(name, key string) corev1.Container {
+	return corev1.Container{
+		Name: "app",
+		Env: []corev1.EnvVar{{
+			Name: "VALUE",
+			ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Key:                  key,
+				},
+			},
+		}},
+	}
+}
+
+func TestShard(t *testing.T) {
+	// big is materialized as three shards, holding a and b, c and d.
+	big := &ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "big"},
+		Spec: map[string]string{
+			"a": sized("a", halfShard),
+			"b": sized("b", halfShard),
+			"c": sized("c", MaxShardSize),
+			"d": "4",
+		},
+	}
+	small := &ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "small"},
+		Spec:       map[string]string{"a": "1"},
+	}
+	resolver := &fakeSnapshots{snapshots: map[string]*ImmutableMap{"big": big, "small": small}}
+	// reprojected is the volume that `kubectl apply` leaves behind when it
+	// merges the original configMap volume into our projection of it.
+	reprojected := shardVolume(map[string][]string{"big-shard-0": {"a"}})
+	reprojected.VolumeSource.ConfigMap = configMapVolume("big", "a", "d").VolumeSource.ConfigMap
+	// shrunk is such a volume, once the reapplied configMap volume is frozen
+	// to a snapshot that fits in one ConfigMap.
+	shrunk := shardVolume(map[string][]string{"big-shard-0": {"a"}})
+	shrunk.VolumeSource.ConfigMap = configMapVolume("small", "a").VolumeSource.ConfigMap
+
+	tests := []struct {
+		name     string
+		resolver Resolver
+		spec     corev1.PodSpec
+		want     corev1.PodSpec
+		wantErr  bool
+	}{{
+		name: "whole volume",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big")}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{shardVolume(map[string][]string{
+			"big-shard-0": nil,
+			"big-shard-1": nil,
+			"big-shard-2": nil,
+		})}},
+	}, {
+		name: "volume of some keys",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big", "a", "d", "b")}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{shardVolume(map[string][]string{
+			"big-shard-0": {"a", "b"},
+			"big-shard-2": {"d"},
+		})}},
+	}, {
+		name: "volume of a missing key",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big", "a", "e")}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big", "a", "e")}},
+	}, {
+		name: "volume reapplied over its projection",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{reprojected}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{shardVolume(map[string][]string{
+			"big-shard-0": {"a"},
+			"big-shard-2": {"d"},
+		})}},
+	}, {
+		name: "volume reapplied over the projection of a larger snapshot",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{shrunk}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("small", "a")}},
+	}, {
+		name: "volume of a snapshot in one ConfigMap",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("small")}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("small")}},
+	}, {
+		name: "volume of a ConfigMap",
+		spec: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("other")}},
+		want: corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("other")}},
+	}, {
+		name: "environment",
+		spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{envFrom("big", "d")},
+			Containers:     []corev1.Container{envFrom("big", "c"), envFrom("small", "a")},
+		},
+		want: corev1.PodSpec{
+			InitContainers: []corev1.Container{envFrom("big-shard-2", "d")},
+			Containers:     []corev1.Container{envFrom("big-shard-1", "c"), envFrom("small", "a")},
+		},
+	}, {
+		name: "environment of a missing key",
+		spec: corev1.PodSpec{Containers: []corev1.Container{envFrom("big", "e")}},
+		want: corev1.PodSpec{Containers: []corev1.Container{envFrom("big", "e")}},
+	}, {
+		name:     "unable to fetch the snapshot",
+		resolver: &fakeSnapshots{err: errors.New("boom")},
+		spec:     corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big")}},
+		want:     corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("big")}},
+		wantErr:  true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.resolver
+			if r == nil {
+				r = resolver
+			}
+			wp := withPod()
+			wp.Spec.Template.Spec = test.spec
+			errs := wp.shard(WithResolver(context.Background(), r))
+			if (errs != nil) != test.wantErr {
+				t.Errorf("shard() = %v, wanted error: %v", errs, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, wp.Spec.Template.Spec); diff != "" {
+				t.Errorf("PodSpec (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
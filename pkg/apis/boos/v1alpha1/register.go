@@ -49,6 +49,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&MutableMapList{},
 		&ImmutableMap{},
 		&ImmutableMapList{},
+		&MapTag{},
+		&MapTagList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
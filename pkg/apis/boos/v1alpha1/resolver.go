@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+)
+
+// Resolver resolves references to MutableMaps into references to the
+// frozen ConfigMap that should be used in their place.
+type Resolver interface {
+	// Resolve returns the name of the ConfigMap that should be referenced
+	// in place of the named ConfigMap in the given namespace.  When name
+	// does not refer to a MutableMap, it is returned unchanged.
+	Resolve(ctx context.Context, namespace, name string) (string, error)
+
+	// ResolveSecret returns the name of the Secret that should be
+	// referenced in place of the named Secret in the given namespace.  When
+	// name does not refer to a MutableMap with keys materialized in a
+	// Secret, it is returned unchanged.
+	ResolveSecret(ctx context.Context, namespace, name string) (string, error)
+
+	// Snapshot returns the ImmutableMap behind the named frozen ConfigMap
+	// in the given namespace, or nil when name does not refer to a snapshot.
+	Snapshot(ctx context.Context, namespace, name string) (*ImmutableMap, error)
+
+	// GenerationSnapshot returns the ImmutableMap snapshotting the given
+	// generation of the named MutableMap in the given namespace, or an
+	// error when that generation has no snapshot.
+	GenerationSnapshot(ctx context.Context, namespace, name string, generation int64) (*ImmutableMap, error)
+}
+
+// ReferencePolicy determines how references to keys that are missing from
+// the snapshot of a MutableMap are handled.
+type ReferencePolicy string
+
+const (
+	// ReferencePolicyDeny denies admission of resources with references to
+	// missing keys.
+	ReferencePolicyDeny ReferencePolicy = "Deny"
+
+	// ReferencePolicyWarn admits resources with references to missing keys,
+	// but records a warning in the logs and audit log.
+	ReferencePolicyWarn ReferencePolicy = "Warn"
+)
+
+// identity is the Resolver used when none has been attached to the context,
+// it leaves all references unchanged.
+type identity struct{}
+
+func (identity) Resolve(ctx context.Context, namespace, name string) (string, error) {
+	return name, nil
+}
+
+func (identity) ResolveSecret(ctx context.Context, namespace, name string) (string, error) {
+	return name, nil
+}
+
+func (identity) Snapshot(ctx context.Context, namespace, name string) (*ImmutableMap, error) {
+	return nil, nil
+}
+
+func (identity) GenerationSnapshot(ctx context.Context, namespace, name string, generation int64) (*ImmutableMap, error) {
+	return nil, nil
+}
+
+type resolverKey struct{}
+
+// WithResolver attaches the provided Resolver to the context for use in
+// defaulting and validating resources that reference ConfigMaps.
+func WithResolver(ctx context.Context, r Resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+// GetResolver returns the Resolver attached to the context.
+func GetResolver(ctx context.Context) Resolver {
+	if r, ok := ctx.Value(resolverKey{}).(Resolver); ok {
+		return r
+	}
+	return identity{}
+}
+
+type referencePolicyKey struct{}
+
+// WithReferencePolicy attaches the provided ReferencePolicy to the context
+// for use in validating resources that reference ConfigMaps.
+func WithReferencePolicy(ctx context.Context, p ReferencePolicy) context.Context {
+	return context.WithValue(ctx, referencePolicyKey{}, p)
+}
+
+// GetReferencePolicy returns the ReferencePolicy attached to the context,
+// which defaults to ReferencePolicyDeny.
+func GetReferencePolicy(ctx context.Context) ReferencePolicy {
+	if p, ok := ctx.Value(referencePolicyKey{}).(ReferencePolicy); ok {
+		return p
+	}
+	return ReferencePolicyDeny
+}
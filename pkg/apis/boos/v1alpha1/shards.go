@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// MaxShardSize bounds the size of the data of each ConfigMap materialized
+// from an ImmutableMap, leaving headroom for its metadata below the 1MiB
+// that the API server accepts.
+const MaxShardSize = 1<<20 - 64<<10
+
+// shardSuffix matches the suffix that ShardName appends to the name of
+// the snapshot.
+var shardSuffix = regexp.MustCompile(`-shard-[0-9]+$`)
+
+// Shards partitions the keys of the ImmutableMap among the ConfigMaps in
+// which it is materialized, so that the data of none exceeds MaxShardSize.
+// Keys are packed in order, so every caller arrives at the same partition.
+func (im *ImmutableMap) Shards() [][]string {
+	keys := make([]string, 0, len(im.Spec))
+	for k := range im.Spec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var shards [][]string
+	var current []string
+	size := 0
+	for _, k := range keys {
+		n := len(k) + len(im.Spec[k])
+		if len(current) > 0 && size+n > MaxShardSize {
+			shards = append(shards, current)
+			current, size = nil, 0
+		}
+		current = append(current, k)
+		size += n
+	}
+	return append(shards, current)
+}
+
+// OversizedKeys returns the keys of data that do not fit in a shard on
+// their own, and so cannot be materialized in any ConfigMap.
+func OversizedKeys(data map[string]string) []string {
+	var keys []string
+	for k, v := range data {
+		if len(k)+len(v) > MaxShardSize {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigMapNames returns the names of the ConfigMaps in which the
+// ImmutableMap is materialized.
+func (im *ImmutableMap) ConfigMapNames() []string {
+	shards := im.Shards()
+	if len(shards) == 1 {
+		return []string{im.Name}
+	}
+	names := make([]string, len(shards))
+	for i := range shards {
+		names[i] = ShardName(im.Name, i)
+	}
+	return names
+}
+
+// ShardName returns the name of the ConfigMap holding the i-th shard of
+// the named snapshot, when its content is split across several.
+func ShardName(snapshot string, i int) string {
+	return fmt.Sprintf("%s-shard-%d", snapshot, i)
+}
+
+// SnapshotName returns the name of the snapshot that the named ConfigMap
+// materializes, which is the name of the ConfigMap itself unless it is a
+// shard.
+func SnapshotName(configMap string) string {
+	return shardSuffix.ReplaceAllString(configMap, "")
+}
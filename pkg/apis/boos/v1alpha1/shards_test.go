@@ -0,0 +1,124 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sized returns a value that, stored under the key, takes up n bytes of a
+// shard.
+func sized(key string, n int) string {
+	return strings.Repeat("x", n-len(key))
+}
+
+// halfShard is the size of a key and value of which two fill a shard.
+const halfShard = MaxShardSize / 2
+
+func TestShards(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          map[string]string
+		wantShards    [][]string
+		wantNames     []string
+		wantOversized []string
+	}{{
+		name:       "empty",
+		wantShards: [][]string{nil},
+		wantNames:  []string{"foo-00001"},
+	}, {
+		name:       "small",
+		spec:       map[string]string{"b": "2", "a": "1"},
+		wantShards: [][]string{{"a", "b"}},
+		wantNames:  []string{"foo-00001"},
+	}, {
+		name:       "key exactly the size of a shard",
+		spec:       map[string]string{"a": sized("a", MaxShardSize)},
+		wantShards: [][]string{{"a"}},
+		wantNames:  []string{"foo-00001"},
+	}, {
+		name:       "keys exactly filling a shard",
+		spec:       map[string]string{"a": sized("a", halfShard), "b": sized("b", halfShard)},
+		wantShards: [][]string{{"a", "b"}},
+		wantNames:  []string{"foo-00001"},
+	}, {
+		name: "one byte over a shard",
+		spec: map[string]string{
+			"a": sized("a", halfShard),
+			"b": sized("b", halfShard),
+			"c": "",
+		},
+		wantShards: [][]string{{"a", "b"}, {"c"}},
+		wantNames:  []string{"foo-00001-shard-0", "foo-00001-shard-1"},
+	}, {
+		name: "keys packed in order",
+		spec: map[string]string{
+			"a": sized("a", halfShard),
+			"b": sized("b", MaxShardSize),
+			"c": sized("c", halfShard),
+			"d": sized("d", halfShard),
+		},
+		wantShards: [][]string{{"a"}, {"b"}, {"c", "d"}},
+		wantNames:  []string{"foo-00001-shard-0", "foo-00001-shard-1", "foo-00001-shard-2"},
+	}, {
+		name: "key larger than a shard",
+		spec: map[string]string{
+			"a": "1",
+			"b": sized("b", MaxShardSize+1),
+			"c": "3",
+		},
+		wantShards:    [][]string{{"a"}, {"b"}, {"c"}},
+		wantNames:     []string{"foo-00001-shard-0", "foo-00001-shard-1", "foo-00001-shard-2"},
+		wantOversized: []string{"b"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			im := &ImmutableMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-00001"},
+				Spec:       test.spec,
+			}
+			if diff := cmp.Diff(test.wantShards, im.Shards()); diff != "" {
+				t.Errorf("Shards (-want, +got) = %v", diff)
+			}
+			if diff := cmp.Diff(test.wantNames, im.ConfigMapNames()); diff != "" {
+				t.Errorf("ConfigMapNames (-want, +got) = %v", diff)
+			}
+			if diff := cmp.Diff(test.wantOversized, OversizedKeys(test.spec)); diff != "" {
+				t.Errorf("OversizedKeys (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	for name, want := range map[string]string{
+		"foo-00001":          "foo-00001",
+		"foo-00001-shard-0":  "foo-00001",
+		"foo-00001-shard-12": "foo-00001",
+		"foo-shard-x":        "foo-shard-x",
+		"foo-shard-0-bar":    "foo-shard-0-bar",
+	} {
+		if got := SnapshotName(name); got != want {
+			t.Errorf("SnapshotName(%s) = %s, wanted %s", name, got, want)
+		}
+	}
+}
@@ -21,9 +21,52 @@ limitations under the License.
 package v1alpha1
 
 import (
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Consumer) DeepCopyInto(out *Consumer) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Consumer.
+func (in *Consumer) DeepCopy() *Consumer {
+	if in == nil {
+		return nil
+	}
+	out := new(Consumer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsumerStatus) DeepCopyInto(out *ConsumerStatus) {
+	*out = *in
+	if in.StaleSince != nil {
+		in, out := &in.StaleSince, &out.StaleSince
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsumerStatus.
+func (in *ConsumerStatus) DeepCopy() *ConsumerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsumerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImmutableMap) DeepCopyInto(out *ImmutableMap) {
 	*out = *in
@@ -36,6 +79,7 @@ func (in *ImmutableMap) DeepCopyInto(out *ImmutableMap) {
 			(*out)[key] = val
 		}
 	}
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -90,6 +134,109 @@ func (in *ImmutableMapList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImmutableMapStatus) DeepCopyInto(out *ImmutableMapStatus) {
+	*out = *in
+	if in.UnreferencedSince != nil {
+		in, out := &in.UnreferencedSince, &out.UnreferencedSince
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(duckv1alpha1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImmutableMapStatus.
+func (in *ImmutableMapStatus) DeepCopy() *ImmutableMapStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImmutableMapStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MapTag) DeepCopyInto(out *MapTag) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MapTag.
+func (in *MapTag) DeepCopy() *MapTag {
+	if in == nil {
+		return nil
+	}
+	out := new(MapTag)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MapTag) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MapTagList) DeepCopyInto(out *MapTagList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MapTag, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MapTagList.
+func (in *MapTagList) DeepCopy() *MapTagList {
+	if in == nil {
+		return nil
+	}
+	out := new(MapTagList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MapTagList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MapTagSpec) DeepCopyInto(out *MapTagSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MapTagSpec.
+func (in *MapTagSpec) DeepCopy() *MapTagSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MapTagSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MutableMap) DeepCopyInto(out *MutableMap) {
 	*out = *in
@@ -102,6 +249,21 @@ func (in *MutableMap) DeepCopyInto(out *MutableMap) {
 			(*out)[key] = val
 		}
 	}
+	if in.Render != nil {
+		in, out := &in.Render, &out.Render
+		*out = make(map[string]RenderFormat, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = make(map[string]ValueSource, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -156,6 +318,29 @@ func (in *MutableMapList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MutableMapStatus) DeepCopyInto(out *MutableMapStatus) {
+	*out = *in
+	if in.Consumers != nil {
+		in, out := &in.Consumers, &out.Consumers
+		*out = make([]ConsumerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MutableMapStatus.
+func (in *MutableMapStatus) DeepCopy() *MutableMapStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MutableMapStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodSpeccable) DeepCopyInto(out *PodSpeccable) {
 	*out = *in
@@ -174,6 +359,27 @@ func (in *PodSpeccable) DeepCopy() *PodSpeccable {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValueSource) DeepCopyInto(out *ValueSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValueSource.
+func (in *ValueSource) DeepCopy() *ValueSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ValueSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WithPod) DeepCopyInto(out *WithPod) {
 	*out = *in
@@ -28,6 +28,7 @@ import (
 type BoosV1alpha1Interface interface {
 	RESTClient() rest.Interface
 	ImmutableMapsGetter
+	MapTagsGetter
 	MutableMapsGetter
 	WithPodsGetter
 }
@@ -41,6 +42,10 @@ func (c *BoosV1alpha1Client) ImmutableMaps(namespace string) ImmutableMapInterfa
 	return newImmutableMaps(c, namespace)
 }
 
+func (c *BoosV1alpha1Client) MapTags(namespace string) MapTagInterface {
+	return newMapTags(c, namespace)
+}
+
 func (c *BoosV1alpha1Client) MutableMaps(namespace string) MutableMapInterface {
 	return newMutableMaps(c, namespace)
 }
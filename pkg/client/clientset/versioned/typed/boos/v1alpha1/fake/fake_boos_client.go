@@ -32,6 +32,10 @@ func (c *FakeBoosV1alpha1) ImmutableMaps(namespace string) v1alpha1.ImmutableMap
 	return &FakeImmutableMaps{c, namespace}
 }
 
+func (c *FakeBoosV1alpha1) MapTags(namespace string) v1alpha1.MapTagInterface {
+	return &FakeMapTags{c, namespace}
+}
+
 func (c *FakeBoosV1alpha1) MutableMaps(namespace string) v1alpha1.MutableMapInterface {
 	return &FakeMutableMaps{c, namespace}
 }
@@ -100,6 +100,18 @@ func (c *FakeImmutableMaps) Update(immutableMap *v1alpha1.ImmutableMap) (result
 	return obj.(*v1alpha1.ImmutableMap), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeImmutableMaps) UpdateStatus(immutableMap *v1alpha1.ImmutableMap) (*v1alpha1.ImmutableMap, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(immutablemapsResource, "status", c.ns, immutableMap), &v1alpha1.ImmutableMap{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ImmutableMap), err
+}
+
 // Delete takes name of the immutableMap and deletes it. Returns an error if one occurs.
 func (c *FakeImmutableMaps) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
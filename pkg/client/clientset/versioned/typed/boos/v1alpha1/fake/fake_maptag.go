@@ -0,0 +1,128 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeMapTags implements MapTagInterface
+type FakeMapTags struct {
+	Fake *FakeBoosV1alpha1
+	ns   string
+}
+
+var maptagsResource = schema.GroupVersionResource{Group: "boos.mattmoor.io", Version: "v1alpha1", Resource: "maptags"}
+
+var maptagsKind = schema.GroupVersionKind{Group: "boos.mattmoor.io", Version: "v1alpha1", Kind: "MapTag"}
+
+// Get takes name of the mapTag, and returns the corresponding mapTag object, and an error if there is any.
+func (c *FakeMapTags) Get(name string, options v1.GetOptions) (result *v1alpha1.MapTag, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(maptagsResource, c.ns, name), &v1alpha1.MapTag{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.MapTag), err
+}
+
+// List takes label and field selectors, and returns the list of MapTags that match those selectors.
+func (c *FakeMapTags) List(opts v1.ListOptions) (result *v1alpha1.MapTagList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(maptagsResource, maptagsKind, c.ns, opts), &v1alpha1.MapTagList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.MapTagList{ListMeta: obj.(*v1alpha1.MapTagList).ListMeta}
+	for _, item := range obj.(*v1alpha1.MapTagList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested mapTags.
+func (c *FakeMapTags) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(maptagsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a mapTag and creates it.  Returns the server's representation of the mapTag, and an error, if there is any.
+func (c *FakeMapTags) Create(mapTag *v1alpha1.MapTag) (result *v1alpha1.MapTag, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(maptagsResource, c.ns, mapTag), &v1alpha1.MapTag{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.MapTag), err
+}
+
+// Update takes the representation of a mapTag and updates it. Returns the server's representation of the mapTag, and an error, if there is any.
+func (c *FakeMapTags) Update(mapTag *v1alpha1.MapTag) (result *v1alpha1.MapTag, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(maptagsResource, c.ns, mapTag), &v1alpha1.MapTag{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.MapTag), err
+}
+
+// Delete takes name of the mapTag and deletes it. Returns an error if one occurs.
+func (c *FakeMapTags) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(maptagsResource, c.ns, name), &v1alpha1.MapTag{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeMapTags) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(maptagsResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.MapTagList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched mapTag.
+func (c *FakeMapTags) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.MapTag, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(maptagsResource, c.ns, name, data, subresources...), &v1alpha1.MapTag{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.MapTag), err
+}
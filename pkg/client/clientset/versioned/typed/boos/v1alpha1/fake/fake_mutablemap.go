@@ -100,6 +100,18 @@ func (c *FakeMutableMaps) Update(mutableMap *v1alpha1.MutableMap) (result *v1alp
 	return obj.(*v1alpha1.MutableMap), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeMutableMaps) UpdateStatus(mutableMap *v1alpha1.MutableMap) (*v1alpha1.MutableMap, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(mutablemapsResource, "status", c.ns, mutableMap), &v1alpha1.MutableMap{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.MutableMap), err
+}
+
 // Delete takes name of the mutableMap and deletes it. Returns an error if one occurs.
 func (c *FakeMutableMaps) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
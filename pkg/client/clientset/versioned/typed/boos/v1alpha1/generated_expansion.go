@@ -20,6 +20,8 @@ package v1alpha1
 
 type ImmutableMapExpansion interface{}
 
+type MapTagExpansion interface{}
+
 type MutableMapExpansion interface{}
 
 type WithPodExpansion interface{}
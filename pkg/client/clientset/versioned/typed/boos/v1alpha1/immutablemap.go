@@ -37,6 +37,7 @@ type ImmutableMapsGetter interface {
 type ImmutableMapInterface interface {
 	Create(*v1alpha1.ImmutableMap) (*v1alpha1.ImmutableMap, error)
 	Update(*v1alpha1.ImmutableMap) (*v1alpha1.ImmutableMap, error)
+	UpdateStatus(*v1alpha1.ImmutableMap) (*v1alpha1.ImmutableMap, error)
 	Delete(name string, options *v1.DeleteOptions) error
 	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
 	Get(name string, options v1.GetOptions) (*v1alpha1.ImmutableMap, error)
@@ -120,6 +121,22 @@ func (c *immutableMaps) Update(immutableMap *v1alpha1.ImmutableMap) (result *v1a
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *immutableMaps) UpdateStatus(immutableMap *v1alpha1.ImmutableMap) (result *v1alpha1.ImmutableMap, err error) {
+	result = &v1alpha1.ImmutableMap{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("immutablemaps").
+		Name(immutableMap.Name).
+		SubResource("status").
+		Body(immutableMap).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the immutableMap and deletes it. Returns an error if one occurs.
 func (c *immutableMaps) Delete(name string, options *v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	scheme "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// MapTagsGetter has a method to return a MapTagInterface.
+// A group's client should implement this interface.
+type MapTagsGetter interface {
+	MapTags(namespace string) MapTagInterface
+}
+
+// MapTagInterface has methods to work with MapTag resources.
+type MapTagInterface interface {
+	Create(*v1alpha1.MapTag) (*v1alpha1.MapTag, error)
+	Update(*v1alpha1.MapTag) (*v1alpha1.MapTag, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
+	Get(name string, options v1.GetOptions) (*v1alpha1.MapTag, error)
+	List(opts v1.ListOptions) (*v1alpha1.MapTagList, error)
+	Watch(opts v1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.MapTag, err error)
+	MapTagExpansion
+}
+
+// mapTags implements MapTagInterface
+type mapTags struct {
+	client rest.Interface
+	ns     string
+}
+
+// newMapTags returns a MapTags
+func newMapTags(c *BoosV1alpha1Client, namespace string) *mapTags {
+	return &mapTags{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the mapTag, and returns the corresponding mapTag object, and an error if there is any.
+func (c *mapTags) Get(name string, options v1.GetOptions) (result *v1alpha1.MapTag, err error) {
+	result = &v1alpha1.MapTag{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("maptags").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of MapTags that match those selectors.
+func (c *mapTags) List(opts v1.ListOptions) (result *v1alpha1.MapTagList, err error) {
+	result = &v1alpha1.MapTagList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("maptags").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested mapTags.
+func (c *mapTags) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("maptags").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch()
+}
+
+// Create takes the representation of a mapTag and creates it.  Returns the server's representation of the mapTag, and an error, if there is any.
+func (c *mapTags) Create(mapTag *v1alpha1.MapTag) (result *v1alpha1.MapTag, err error) {
+	result = &v1alpha1.MapTag{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("maptags").
+		Body(mapTag).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a mapTag and updates it. Returns the server's representation of the mapTag, and an error, if there is any.
+func (c *mapTags) Update(mapTag *v1alpha1.MapTag) (result *v1alpha1.MapTag, err error) {
+	result = &v1alpha1.MapTag{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("maptags").
+		Name(mapTag.Name).
+		Body(mapTag).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the mapTag and deletes it. Returns an error if one occurs.
+func (c *mapTags) Delete(name string, options *v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("maptags").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *mapTags) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("maptags").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched mapTag.
+func (c *mapTags) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.MapTag, err error) {
+	result = &v1alpha1.MapTag{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("maptags").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
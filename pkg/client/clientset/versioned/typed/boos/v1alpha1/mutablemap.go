@@ -37,6 +37,7 @@ type MutableMapsGetter interface {
 type MutableMapInterface interface {
 	Create(*v1alpha1.MutableMap) (*v1alpha1.MutableMap, error)
 	Update(*v1alpha1.MutableMap) (*v1alpha1.MutableMap, error)
+	UpdateStatus(*v1alpha1.MutableMap) (*v1alpha1.MutableMap, error)
 	Delete(name string, options *v1.DeleteOptions) error
 	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
 	Get(name string, options v1.GetOptions) (*v1alpha1.MutableMap, error)
@@ -120,6 +121,22 @@ func (c *mutableMaps) Update(mutableMap *v1alpha1.MutableMap) (result *v1alpha1.
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *mutableMaps) UpdateStatus(mutableMap *v1alpha1.MutableMap) (result *v1alpha1.MutableMap, err error) {
+	result = &v1alpha1.MutableMap{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("mutablemaps").
+		Name(mutableMap.Name).
+		SubResource("status").
+		Body(mutableMap).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the mutableMap and deletes it. Returns an error if one occurs.
 func (c *mutableMaps) Delete(name string, options *v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -26,6 +26,8 @@ import (
 type Interface interface {
 	// ImmutableMaps returns a ImmutableMapInformer.
 	ImmutableMaps() ImmutableMapInformer
+	// MapTags returns a MapTagInformer.
+	MapTags() MapTagInformer
 	// MutableMaps returns a MutableMapInformer.
 	MutableMaps() MutableMapInformer
 	// WithPods returns a WithPodInformer.
@@ -48,6 +50,11 @@ func (v *version) ImmutableMaps() ImmutableMapInformer {
 	return &immutableMapInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// MapTags returns a MapTagInformer.
+func (v *version) MapTags() MapTagInformer {
+	return &mapTagInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // MutableMaps returns a MutableMapInformer.
 func (v *version) MutableMaps() MutableMapInformer {
 	return &mutableMapInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
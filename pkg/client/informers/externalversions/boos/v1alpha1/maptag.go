@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	time "time"
+
+	boosv1alpha1 "github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	versioned "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/internalinterfaces"
+	v1alpha1 "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// MapTagInformer provides access to a shared informer and lister for
+// MapTags.
+type MapTagInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.MapTagLister
+}
+
+type mapTagInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewMapTagInformer constructs a new informer for MapTag type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewMapTagInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredMapTagInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredMapTagInformer constructs a new informer for MapTag type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredMapTagInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.BoosV1alpha1().MapTags(namespace).List(options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.BoosV1alpha1().MapTags(namespace).Watch(options)
+			},
+		},
+		&boosv1alpha1.MapTag{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *mapTagInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredMapTagInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *mapTagInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&boosv1alpha1.MapTag{}, f.defaultInformer)
+}
+
+func (f *mapTagInformer) Lister() v1alpha1.MapTagLister {
+	return v1alpha1.NewMapTagLister(f.Informer().GetIndexer())
+}
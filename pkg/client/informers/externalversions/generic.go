@@ -55,6 +55,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=boos.mattmoor.io, Version=v1alpha1
 	case v1alpha1.SchemeGroupVersion.WithResource("immutablemaps"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Boos().V1alpha1().ImmutableMaps().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("maptags"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Boos().V1alpha1().MapTags().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("mutablemaps"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Boos().V1alpha1().MutableMaps().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("withpods"):
@@ -18,13 +18,13 @@ limitations under the License.
 
 package v1alpha1
 
-// ImmutableMapListerExpansion allows custom methods to be added to
-// ImmutableMapLister.
-type ImmutableMapListerExpansion interface{}
+// MapTagListerExpansion allows custom methods to be added to
+// MapTagLister.
+type MapTagListerExpansion interface{}
 
-// ImmutableMapNamespaceListerExpansion allows custom methods to be added to
-// ImmutableMapNamespaceLister.
-type ImmutableMapNamespaceListerExpansion interface{}
+// MapTagNamespaceListerExpansion allows custom methods to be added to
+// MapTagNamespaceLister.
+type MapTagNamespaceListerExpansion interface{}
 
 // MutableMapListerExpansion allows custom methods to be added to
 // MutableMapLister.
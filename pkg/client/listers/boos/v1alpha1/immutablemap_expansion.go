@@ -0,0 +1,52 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+	v1alpha1 "github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+)
+
+// ImmutableMapListerExpansion allows custom methods to be added to
+// ImmutableMapLister.
+type ImmutableMapListerExpansion interface{}
+
+// ImmutableMapNamespaceListerExpansion allows custom methods to be added to
+// ImmutableMapNamespaceLister.
+type ImmutableMapNamespaceListerExpansion interface {
+	// ListSnapshots lists the ImmutableMaps snapshotting the named
+	// MutableMap, ordered by generation.
+	ListSnapshots(mutableMap string) ([]*v1alpha1.ImmutableMap, error)
+}
+
+// ListSnapshots implements ImmutableMapNamespaceListerExpansion
+func (s immutableMapNamespaceLister) ListSnapshots(mutableMap string) ([]*v1alpha1.ImmutableMap, error) {
+	ims, err := s.List(labels.SelectorFromSet(labels.Set{
+		boos.MutableMapLabelKey: v1alpha1.MutableMapLabel(mutableMap),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ims, func(i, j int) bool {
+		return ims[i].SnapshotGeneration() < ims[j].SnapshotGeneration()
+	})
+	return ims, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// MapTagLister helps list MapTags.
+type MapTagLister interface {
+	// List lists all MapTags in the indexer.
+	List(selector labels.Selector) (ret []*v1alpha1.MapTag, err error)
+	// MapTags returns an object that can list and get MapTags.
+	MapTags(namespace string) MapTagNamespaceLister
+	MapTagListerExpansion
+}
+
+// mapTagLister implements the MapTagLister interface.
+type mapTagLister struct {
+	indexer cache.Indexer
+}
+
+// NewMapTagLister returns a new MapTagLister.
+func NewMapTagLister(indexer cache.Indexer) MapTagLister {
+	return &mapTagLister{indexer: indexer}
+}
+
+// List lists all MapTags in the indexer.
+func (s *mapTagLister) List(selector labels.Selector) (ret []*v1alpha1.MapTag, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.MapTag))
+	})
+	return ret, err
+}
+
+// MapTags returns an object that can list and get MapTags.
+func (s *mapTagLister) MapTags(namespace string) MapTagNamespaceLister {
+	return mapTagNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// MapTagNamespaceLister helps list and get MapTags.
+type MapTagNamespaceLister interface {
+	// List lists all MapTags in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1alpha1.MapTag, err error)
+	// Get retrieves the MapTag from the indexer for a given namespace and name.
+	Get(name string) (*v1alpha1.MapTag, error)
+	MapTagNamespaceListerExpansion
+}
+
+// mapTagNamespaceLister implements the MapTagNamespaceLister
+// interface.
+type mapTagNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all MapTags in the indexer for a given namespace.
+func (s mapTagNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.MapTag, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.MapTag))
+	})
+	return ret, err
+}
+
+// Get retrieves the MapTag from the indexer for a given namespace and name.
+func (s mapTagNamespaceLister) Get(name string) (*v1alpha1.MapTag, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("maptag"), name)
+	}
+	return obj.(*v1alpha1.MapTag), nil
+}
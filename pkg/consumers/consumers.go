@@ -0,0 +1,282 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumers
+
+import (
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	appsv1informers "k8s.io/client-go/informers/apps/v1"
+	batchv1informers "k8s.io/client-go/informers/batch/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	batchv1listers "k8s.io/client-go/listers/batch/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
+	listers "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+)
+
+// Lister finds the workloads consuming the snapshots of a MutableMap
+// among the kinds of resources that the webhook freezes, and Pods that
+// no controller manages.
+type Lister struct {
+	immutableMapLister listers.ImmutableMapLister
+
+	deploymentLister  appsv1listers.DeploymentLister
+	replicaSetLister  appsv1listers.ReplicaSetLister
+	statefulSetLister appsv1listers.StatefulSetLister
+	daemonSetLister   appsv1listers.DaemonSetLister
+	jobLister         batchv1listers.JobLister
+	podLister         corev1listers.PodLister
+
+	informers []cache.SharedIndexInformer
+}
+
+// New returns a Lister backed by the provided informers.
+func New(
+	immutableMapInformer informers.ImmutableMapInformer,
+	deploymentInformer appsv1informers.DeploymentInformer,
+	replicaSetInformer appsv1informers.ReplicaSetInformer,
+	statefulSetInformer appsv1informers.StatefulSetInformer,
+	daemonSetInformer appsv1informers.DaemonSetInformer,
+	jobInformer batchv1informers.JobInformer,
+	podInformer corev1informers.PodInformer,
+) *Lister {
+	return &Lister{
+		immutableMapLister: immutableMapInformer.Lister(),
+		deploymentLister:   deploymentInformer.Lister(),
+		replicaSetLister:   replicaSetInformer.Lister(),
+		statefulSetLister:  statefulSetInformer.Lister(),
+		daemonSetLister:    daemonSetInformer.Lister(),
+		jobLister:          jobInformer.Lister(),
+		podLister:          podInformer.Lister(),
+		informers: []cache.SharedIndexInformer{
+			deploymentInformer.Informer(),
+			replicaSetInformer.Informer(),
+			statefulSetInformer.Informer(),
+			daemonSetInformer.Informer(),
+			jobInformer.Informer(),
+			podInformer.Informer(),
+		},
+	}
+}
+
+// Check that we implement the v1alpha1.ConsumerLister interface.
+var _ v1alpha1.ConsumerLister = (*Lister)(nil)
+
+// workload is a resource that may consume snapshots.
+type workload struct {
+	gvk      schema.GroupVersionKind
+	obj      metav1.Object
+	template *corev1.PodTemplateSpec
+}
+
+// references returns the ConfigMaps and Secrets referenced by the
+// workload, mapped to the keys of each that it requires.
+func (w *workload) references() map[string]sets.String {
+	return (*v1alpha1.PodSpeccable)(w.template).SnapshotReferences()
+}
+
+// asWorkload returns the workload for the provided informer object.
+func asWorkload(obj interface{}) (*workload, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &workload{appsv1.SchemeGroupVersion.WithKind("Deployment"), o, &o.Spec.Template}, true
+	case *appsv1.ReplicaSet:
+		// ReplicaSets managed by a Deployment are accounted for by it until
+		// they are scaled down, but the ReplicaSets of previous revisions
+		// keep running pods for the duration of a rollout.
+		if metav1.GetControllerOf(o) != nil && !hasReplicas(o) {
+			return nil, false
+		}
+		return &workload{appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), o, &o.Spec.Template}, true
+	case *appsv1.StatefulSet:
+		return &workload{appsv1.SchemeGroupVersion.WithKind("StatefulSet"), o, &o.Spec.Template}, true
+	case *appsv1.DaemonSet:
+		return &workload{appsv1.SchemeGroupVersion.WithKind("DaemonSet"), o, &o.Spec.Template}, true
+	case *batchv1.Job:
+		return &workload{batchv1.SchemeGroupVersion.WithKind("Job"), o, &o.Spec.Template}, true
+	case *corev1.Pod:
+		// Pods managed by a controller are accounted for by it.
+		if metav1.GetControllerOf(o) != nil {
+			return nil, false
+		}
+		return &workload{corev1.SchemeGroupVersion.WithKind("Pod"), o,
+			&corev1.PodTemplateSpec{ObjectMeta: o.ObjectMeta, Spec: o.Spec}}, true
+	default:
+		return nil, false
+	}
+}
+
+// hasReplicas returns whether the ReplicaSet wants or still has pods.
+func hasReplicas(rs *appsv1.ReplicaSet) bool {
+	return (rs.Spec.Replicas != nil && *rs.Spec.Replicas > 0) || rs.Status.Replicas > 0
+}
+
+// workloads lists the workloads in the provided namespace.
+func (l *Lister) workloads(namespace string) ([]*workload, error) {
+	var objs []interface{}
+	deployments, err := l.deploymentLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments {
+		objs = append(objs, d)
+	}
+	replicaSets, err := l.replicaSetLister.ReplicaSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range replicaSets {
+		objs = append(objs, rs)
+	}
+	statefulSets, err := l.statefulSetLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, ss := range statefulSets {
+		objs = append(objs, ss)
+	}
+	daemonSets, err := l.daemonSetLister.DaemonSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range daemonSets {
+		objs = append(objs, ds)
+	}
+	jobs, err := l.jobLister.Jobs(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		objs = append(objs, j)
+	}
+	pods, err := l.podLister.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pods {
+		objs = append(objs, p)
+	}
+
+	var ws []*workload
+	for _, obj := range objs {
+		if w, ok := asWorkload(obj); ok {
+			ws = append(ws, w)
+		}
+	}
+	return ws, nil
+}
+
+// ListConsumers implements v1alpha1.ConsumerLister
+func (l *Lister) ListConsumers(mm *v1alpha1.MutableMap) ([]v1alpha1.Consumer, error) {
+	snapshots, err := l.snapshots(mm)
+	if err != nil {
+		return nil, err
+	} else if snapshots.Len() == 0 {
+		return nil, nil
+	}
+	ws, err := l.workloads(mm.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var consumers []v1alpha1.Consumer
+	for _, w := range ws {
+		for name, keys := range w.references() {
+			if !snapshots.Has(name) {
+				continue
+			}
+			consumers = append(consumers, v1alpha1.Consumer{
+				APIVersion: w.gvk.GroupVersion().String(),
+				Kind:       w.gvk.Kind,
+				Name:       w.obj.GetName(),
+				Snapshot:   name,
+				Keys:       keys.List(),
+			})
+		}
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].String() < consumers[j].String()
+	})
+	return consumers, nil
+}
+
+// Referenced returns whether any workload references the named ConfigMap.
+func (l *Lister) Referenced(namespace, name string) (bool, error) {
+	ws, err := l.workloads(namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, w := range ws {
+		if _, ok := w.references()[name]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EnqueueReferences returns an informer event handler that passes the
+// namespace/name keys of the ConfigMaps referenced by each workload to
+// the provided function.
+func EnqueueReferences(enqueue func(key string)) func(obj interface{}) {
+	return func(obj interface{}) {
+		w, ok := asWorkload(obj)
+		if !ok {
+			return
+		}
+		for name := range w.references() {
+			enqueue(w.obj.GetNamespace() + "/" + name)
+		}
+	}
+}
+
+// AddEventHandler adds the event handler to the informers of each kind of
+// workload.
+func (l *Lister) AddEventHandler(handler cache.ResourceEventHandler) {
+	for _, informer := range l.informers {
+		informer.AddEventHandler(handler)
+	}
+}
+
+// snapshots returns the names of the ImmutableMaps controlled by the
+// provided MutableMap.
+func (l *Lister) snapshots(mm *v1alpha1.MutableMap) (sets.String, error) {
+	ims, err := l.immutableMapLister.ImmutableMaps(mm.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	names := sets.NewString()
+	for _, im := range ims {
+		if metav1.IsControlledBy(im, mm) {
+			names.Insert(im.Name)
+		}
+	}
+	return names, nil
+}
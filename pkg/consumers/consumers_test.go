@@ -0,0 +1,309 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/kmeta"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions"
+)
+
+const testNamespace = "default"
+
+var (
+	foo = &v1alpha1.MutableMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "foo", UID: types.UID("foo-uid")},
+	}
+	bar = &v1alpha1.MutableMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "bar", UID: types.UID("bar-uid")},
+	}
+	truth = true
+	one   = int32(1)
+	zero  = int32(0)
+)
+
+func snapshot(mm *v1alpha1.MutableMap, name string) *v1alpha1.ImmutableMap {
+	return &v1alpha1.ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       mm.Namespace,
+			Name:            name,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(mm)},
+		},
+	}
+}
+
+func objectMeta(name string, owner metav1.Object, kind string) metav1.ObjectMeta {
+	om := metav1.ObjectMeta{Namespace: testNamespace, Name: name}
+	if owner != nil {
+		om.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       kind,
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+			Controller: &truth,
+		}}
+	}
+	return om
+}
+
+// configMapVolume mounts the keys of the named ConfigMap.
+func configMapVolume(name string, keys ...string) corev1.PodSpec {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+	return corev1.PodSpec{
+		Volumes: []corev1.Volume{{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Items:                items,
+				},
+			},
+		}},
+	}
+}
+
+// configMapEnv reads the key of the named ConfigMap into the environment.
+func configMapEnv(name, key string) corev1.PodSpec {
+	return corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{{
+				Name: "VALUE",
+				ValueFrom: &corev1.EnvVarSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  key,
+					},
+				},
+			}},
+		}},
+	}
+}
+
+// secretVolume mounts the whole of the named Secret.
+func secretVolume(name string) corev1.PodSpec {
+	return corev1.PodSpec{
+		Volumes: []corev1.Volume{{
+			Name: "creds",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: name},
+			},
+		}},
+	}
+}
+
+func template(spec corev1.PodSpec) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{Spec: spec}
+}
+
+// newTestLister returns a Lister whose informers hold the provided objects.
+func newTestLister(t *testing.T, objects ...interface{}) *Lister {
+	kube := kubeinformers.NewSharedInformerFactory(nil, 0)
+	boos := informers.NewSharedInformerFactory(nil, 0)
+	for _, obj := range objects {
+		var informer cache.SharedIndexInformer
+		switch obj.(type) {
+		case *v1alpha1.ImmutableMap:
+			informer = boos.Boos().V1alpha1().ImmutableMaps().Informer()
+		case *appsv1.Deployment:
+			informer = kube.Apps().V1().Deployments().Informer()
+		case *appsv1.ReplicaSet:
+			informer = kube.Apps().V1().ReplicaSets().Informer()
+		case *appsv1.StatefulSet:
+			informer = kube.Apps().V1().StatefulSets().Informer()
+		case *appsv1.DaemonSet:
+			informer = kube.Apps().V1().DaemonSets().Informer()
+		case *batchv1.Job:
+			informer = kube.Batch().V1().Jobs().Informer()
+		case *corev1.Pod:
+			informer = kube.Core().V1().Pods().Informer()
+		default:
+			t.Fatalf("unsupported object %T", obj)
+		}
+		if err := informer.GetIndexer().Add(obj); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	return New(
+		boos.Boos().V1alpha1().ImmutableMaps(),
+		kube.Apps().V1().Deployments(),
+		kube.Apps().V1().ReplicaSets(),
+		kube.Apps().V1().StatefulSets(),
+		kube.Apps().V1().DaemonSets(),
+		kube.Batch().V1().Jobs(),
+		kube.Core().V1().Pods(),
+	)
+}
+
+func TestListConsumers(t *testing.T) {
+	gen1, gen2 := snapshot(foo, "foo-00001"), snapshot(foo, "foo-00002")
+	other := snapshot(bar, "bar-00001")
+
+	web := &appsv1.Deployment{
+		ObjectMeta: objectMeta("web", nil, ""),
+		Spec:       appsv1.DeploymentSpec{Template: template(configMapVolume(gen2.Name, "a", "b"))},
+	}
+	web.UID = types.UID("web-uid")
+	// The ReplicaSet of the previous revision of web, still running pods.
+	rolling := &appsv1.ReplicaSet{
+		ObjectMeta: objectMeta("web-1", web, "Deployment"),
+		Spec:       appsv1.ReplicaSetSpec{Replicas: &zero, Template: template(configMapVolume(gen1.Name, "a"))},
+		Status:     appsv1.ReplicaSetStatus{Replicas: 1},
+	}
+	rolling.UID = types.UID("rolling-uid")
+	// The ReplicaSet of an older revision of web, scaled down.
+	retired := &appsv1.ReplicaSet{
+		ObjectMeta: objectMeta("web-0", web, "Deployment"),
+		Spec:       appsv1.ReplicaSetSpec{Replicas: &zero, Template: template(configMapVolume(gen1.Name))},
+	}
+	// The ReplicaSet of the current revision of web.
+	current := &appsv1.ReplicaSet{
+		ObjectMeta: objectMeta("web-2", web, "Deployment"),
+		Spec:       appsv1.ReplicaSetSpec{Replicas: &one, Template: template(configMapVolume(gen2.Name, "a", "b"))},
+	}
+	objects := []interface{}{
+		gen1, gen2, other, web, rolling, retired, current,
+		// A pod of rolling, which it accounts for.
+		&corev1.Pod{ObjectMeta: objectMeta("web-1-abcde", rolling, "ReplicaSet"), Spec: configMapVolume(gen1.Name, "a")},
+		&corev1.Pod{ObjectMeta: objectMeta("debug", nil, ""), Spec: configMapEnv(gen1.Name, "c")},
+		&batchv1.Job{
+			ObjectMeta: objectMeta("migrate", nil, ""),
+			Spec:       batchv1.JobSpec{Template: template(configMapVolume(v1alpha1.ShardName(gen2.Name, 1), "d"))},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: objectMeta("agent", nil, ""),
+			Spec:       appsv1.DaemonSetSpec{Template: template(secretVolume(gen2.Name))},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: objectMeta("db", nil, ""),
+			Spec:       appsv1.StatefulSetSpec{Template: template(configMapVolume(other.Name, "a"))},
+		},
+	}
+	l := newTestLister(t, objects...)
+
+	got, err := l.ListConsumers(foo)
+	if err != nil {
+		t.Fatalf("ListConsumers() = %v", err)
+	}
+	want := []v1alpha1.Consumer{{
+		APIVersion: "apps/v1",
+		Kind:       "DaemonSet",
+		Name:       "agent",
+		Snapshot:   gen2.Name,
+		Keys:       []string{},
+	}, {
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       "web",
+		Snapshot:   gen2.Name,
+		Keys:       []string{"a", "b"},
+	}, {
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       "migrate",
+		Snapshot:   gen2.Name,
+		Keys:       []string{"d"},
+	}, {
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       "debug",
+		Snapshot:   gen1.Name,
+		Keys:       []string{"c"},
+	}, {
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "web-1",
+		Snapshot:   gen1.Name,
+		Keys:       []string{"a"},
+	}, {
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "web-2",
+		Snapshot:   gen2.Name,
+		Keys:       []string{"a", "b"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListConsumers (-want, +got) = %v", diff)
+	}
+
+	// A MutableMap without snapshots has no consumers.
+	if got, err := l.ListConsumers(&v1alpha1.MutableMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "baz"}}); err != nil || len(got) != 0 {
+		t.Errorf("ListConsumers() = %v, %v, wanted none", got, err)
+	}
+}
+
+func TestReferenced(t *testing.T) {
+	l := newTestLister(t,
+		&corev1.Pod{ObjectMeta: objectMeta("debug", nil, ""), Spec: configMapEnv("foo-00001", "c")},
+		&batchv1.Job{
+			ObjectMeta: objectMeta("migrate", nil, ""),
+			Spec:       batchv1.JobSpec{Template: template(configMapVolume(v1alpha1.ShardName("foo-00002", 0)))},
+		},
+	)
+
+	for name, want := range map[string]bool{
+		"foo-00001": true,
+		// References to a shard count as references to its snapshot.
+		"foo-00002": true,
+		"foo-00003": false,
+	} {
+		if got, err := l.Referenced(testNamespace, name); err != nil {
+			t.Errorf("Referenced(%s) = %v", name, err)
+		} else if got != want {
+			t.Errorf("Referenced(%s) = %v, wanted %v", name, got, want)
+		}
+	}
+}
+
+func TestEnqueueReferences(t *testing.T) {
+	var got []string
+	handler := EnqueueReferences(func(key string) {
+		got = append(got, key)
+	})
+
+	handler(&appsv1.Deployment{
+		ObjectMeta: objectMeta("web", nil, ""),
+		Spec:       appsv1.DeploymentSpec{Template: template(configMapVolume("foo-00002", "a"))},
+	})
+	// Deleted workloads are seen through their tombstones.
+	handler(cache.DeletedFinalStateUnknown{
+		Key: testNamespace + "/debug",
+		Obj: &corev1.Pod{ObjectMeta: objectMeta("debug", nil, ""), Spec: configMapEnv("foo-00001", "c")},
+	})
+	// Pods of a controller are accounted for by it.
+	handler(&corev1.Pod{ObjectMeta: objectMeta("web-abcde", &metav1.ObjectMeta{Name: "web"}, "ReplicaSet"), Spec: configMapEnv("foo-00003", "c")})
+	handler("not a workload")
+
+	want := []string{testNamespace + "/foo-00002", testNamespace + "/foo-00001"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("enqueued (-want, +got) = %v", diff)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package guard protects the ConfigMaps and Secrets materialized from
+// ImmutableMaps from being changed or deleted at admission.
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/knative/pkg/logging"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
+	listers "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/webhook"
+)
+
+// Snapshots denies updates to and deletions of the ConfigMaps and Secrets
+// controlled by ImmutableMaps, except by the exempt users (e.g. our
+// controller).
+type Snapshots struct {
+	client             kubernetes.Interface
+	immutableMapLister listers.ImmutableMapLister
+
+	exemptUsers sets.String
+}
+
+// New returns a Snapshots validator that permits the provided users to
+// update and delete frozen ConfigMaps and Secrets.
+func New(
+	client kubernetes.Interface,
+	immutableMapInformer informers.ImmutableMapInformer,
+	exemptUsers ...string,
+) *Snapshots {
+	return &Snapshots{
+		client:             client,
+		immutableMapLister: immutableMapInformer.Lister(),
+		exemptUsers:        sets.NewString(exemptUsers...),
+	}
+}
+
+// Check that we implement the webhook.ResourceValidator interface.
+var _ webhook.ResourceValidator = (*Snapshots)(nil)
+
+// ValidateOperation implements webhook.ResourceValidator
+func (g *Snapshots) ValidateOperation(ctx context.Context, req *admissionv1beta1.AdmissionRequest) error {
+	if g.exemptUsers.Has(req.UserInfo.Username) {
+		return nil
+	}
+	kind := req.Kind.Kind
+	obj, err := g.existing(req)
+	if apierrs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch %s %s/%s: %v", kind, req.Namespace, req.Name, err)
+	}
+
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.APIVersion != v1alpha1.SchemeGroupVersion.String() || owner.Kind != "ImmutableMap" {
+		return nil
+	}
+	im, err := g.immutableMapLister.ImmutableMaps(obj.GetNamespace()).Get(owner.Name)
+	if apierrs.IsNotFound(err) {
+		// The ImmutableMap is gone, so let the ConfigMap be cleaned up.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch ImmutableMap %s/%s: %v", obj.GetNamespace(), owner.Name, err)
+	} else if im.UID != owner.UID || im.DeletionTimestamp != nil {
+		return nil
+	}
+
+	logging.FromContext(ctx).Warnf("Denying %s of frozen %s %s/%s by %q",
+		req.Operation, kind, obj.GetNamespace(), obj.GetName(), req.UserInfo.Username)
+	if mm := metav1.GetControllerOf(im); mm != nil && mm.Kind == "MutableMap" {
+		return fmt.Errorf("%s %q is a frozen snapshot of MutableMap %q and may not be modified; edit the MutableMap instead",
+			kind, obj.GetName(), mm.Name)
+	}
+	return fmt.Errorf("%s %q is controlled by ImmutableMap %q and may not be modified", kind, obj.GetName(), im.Name)
+}
+
+// object holds the metadata of the resource being admitted, which is all
+// that we need of it.
+type object struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+// existing returns the metadata of the ConfigMap or Secret as it exists
+// prior to the operation.
+func (g *Snapshots) existing(req *admissionv1beta1.AdmissionRequest) (metav1.Object, error) {
+	if len(req.OldObject.Raw) != 0 {
+		obj := &object{}
+		if err := json.Unmarshal(req.OldObject.Raw, obj); err != nil {
+			return nil, fmt.Errorf("cannot decode incoming old object: %v", err)
+		}
+		return obj, nil
+	}
+	// Older API servers do not send the object being deleted.
+	switch kind := req.Kind.Kind; kind {
+	case "ConfigMap":
+		return g.client.CoreV1().ConfigMaps(req.Namespace).Get(req.Name, metav1.GetOptions{})
+	case "Secret":
+		return g.client.CoreV1().Secrets(req.Namespace).Get(req.Name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("unexpected kind %q, want ConfigMap or Secret", kind)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/knative/pkg/kmeta"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	rtesting "github.com/mattmoor/boo-maps/pkg/reconciler/testing"
+)
+
+const (
+	controllerUser = "system:serviceaccount:boomap-system:boomap-controller"
+	someUser       = "jane@example.com"
+)
+
+func snapshot(opts ...func(*v1alpha1.ImmutableMap)) *v1alpha1.ImmutableMap {
+	im := &v1alpha1.ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "foo-abcde-00001",
+			UID:       types.UID("im-uid"),
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(&v1alpha1.MutableMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo", UID: types.UID("mm-uid")},
+			})},
+		},
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+	return im
+}
+
+// controlledBy returns the metadata of a resource of the snapshot's name
+// controlled by it, or by nothing when it is nil.
+func controlledBy(im *v1alpha1.ImmutableMap) metav1.ObjectMeta {
+	om := metav1.ObjectMeta{Namespace: "default", Name: "foo-abcde-00001"}
+	if im != nil {
+		om.OwnerReferences = []metav1.OwnerReference{*kmeta.NewControllerRef(im)}
+	}
+	return om
+}
+
+// request returns the admission request of the user for the operation on
+// the object, which the API server sends along unless omitted.
+func request(user string, op admissionv1beta1.Operation, obj runtime.Object, omitted bool) *admissionv1beta1.AdmissionRequest {
+	om := obj.(metav1.Object)
+	req := &admissionv1beta1.AdmissionRequest{
+		Namespace: om.GetNamespace(),
+		Name:      om.GetName(),
+		Operation: op,
+		UserInfo:  authenticationv1.UserInfo{Username: user},
+	}
+	switch obj.(type) {
+	case *corev1.ConfigMap:
+		req.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	case *corev1.Secret:
+		req.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	}
+	if !omitted {
+		b, err := json.Marshal(obj)
+		if err != nil {
+			panic(err)
+		}
+		req.OldObject.Raw = b
+	}
+	return req
+}
+
+func TestValidateOperation(t *testing.T) {
+	im := snapshot()
+	frozen := &corev1.ConfigMap{ObjectMeta: controlledBy(im)}
+	frozenSecret := &corev1.Secret{ObjectMeta: controlledBy(im)}
+
+	tests := []struct {
+		name      string
+		snapshots []runtime.Object
+		existing  []runtime.Object
+		req       *admissionv1beta1.AdmissionRequest
+		// wantErr is a substring of the expected error, if any.
+		wantErr string
+	}{{
+		name:      "update of a frozen ConfigMap",
+		snapshots: []runtime.Object{im},
+		req:       request(someUser, admissionv1beta1.Update, frozen, false),
+		wantErr:   `ConfigMap "foo-abcde-00001" is a frozen snapshot of MutableMap "foo"`,
+	}, {
+		name:      "deletion of a frozen Secret",
+		snapshots: []runtime.Object{im},
+		req:       request(someUser, admissionv1beta1.Delete, frozenSecret, false),
+		wantErr:   `Secret "foo-abcde-00001" is a frozen snapshot of MutableMap "foo"`,
+	}, {
+		name:      "deletion of a frozen ConfigMap the API server omits",
+		snapshots: []runtime.Object{im},
+		existing:  []runtime.Object{frozen},
+		req:       request(someUser, admissionv1beta1.Delete, frozen, true),
+		wantErr:   "frozen snapshot",
+	}, {
+		name:      "deletion of a missing ConfigMap the API server omits",
+		snapshots: []runtime.Object{im},
+		req:       request(someUser, admissionv1beta1.Delete, frozen, true),
+	}, {
+		name:      "update by the controller",
+		snapshots: []runtime.Object{im},
+		req:       request(controllerUser, admissionv1beta1.Update, frozen, false),
+	}, {
+		name:      "update of an unowned ConfigMap",
+		snapshots: []runtime.Object{im},
+		req:       request(someUser, admissionv1beta1.Update, &corev1.ConfigMap{ObjectMeta: controlledBy(nil)}, false),
+	}, {
+		name:      "update of a ConfigMap owned by something else",
+		snapshots: []runtime.Object{im},
+		req: request(someUser, admissionv1beta1.Update, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "foo-abcde-00001",
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(&v1alpha1.MutableMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo", UID: types.UID("mm-uid")},
+			})},
+		}}, false),
+	}, {
+		name:      "update of a ConfigMap of a snapshot without a MutableMap",
+		snapshots: []runtime.Object{snapshot(func(im *v1alpha1.ImmutableMap) { im.OwnerReferences = nil })},
+		req:       request(someUser, admissionv1beta1.Update, frozen, false),
+		wantErr:   `ConfigMap "foo-abcde-00001" is controlled by ImmutableMap "foo-abcde-00001"`,
+	}, {
+		name: "update of a ConfigMap whose snapshot is gone",
+		req:  request(someUser, admissionv1beta1.Update, frozen, false),
+	}, {
+		name:      "update of a ConfigMap of a previous incarnation of the snapshot",
+		snapshots: []runtime.Object{snapshot(func(im *v1alpha1.ImmutableMap) { im.UID = types.UID("other-uid") })},
+		req:       request(someUser, admissionv1beta1.Update, frozen, false),
+	}, {
+		name: "update of a ConfigMap whose snapshot is being deleted",
+		snapshots: []runtime.Object{snapshot(func(im *v1alpha1.ImmutableMap) {
+			now := metav1.Now()
+			im.DeletionTimestamp = &now
+		})},
+		req: request(someUser, admissionv1beta1.Update, frozen, false),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			informers := rtesting.NewInformers(test.snapshots...)
+			g := New(rtesting.NewKubeClient(test.existing...), informers.Boos.Boos().V1alpha1().ImmutableMaps(), controllerUser)
+
+			err := g.ValidateOperation(context.Background(), test.req)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("ValidateOperation() = %v, wanted nil", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("ValidateOperation() = %v, wanted error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
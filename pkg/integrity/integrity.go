@@ -0,0 +1,201 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package integrity computes and verifies digests of the content of
+// snapshots, optionally signed with a key held by the controller.
+package integrity
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+)
+
+const (
+	digestPrefix    = "sha256:"
+	signaturePrefix = "hmac-sha256:"
+
+	// KeyName is the name of the Secret in the system namespace holding
+	// the key with which digests are signed.
+	KeyName = "boomap-signing-key"
+
+	// KeyKey is the key of the Secret holding the signing key.
+	KeyKey = "key"
+
+	// keySize is the size of the signing keys we generate.
+	keySize = 32
+)
+
+// Digest returns the digest of the provided data, which does not depend
+// on the order of its keys.
+func Digest(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	return digest(keys, func(k string) []byte {
+		return []byte(data[k])
+	})
+}
+
+// DigestBytes returns the digest of the provided binary data, such as
+// that of a Secret, which does not depend on the order of its keys.
+func DigestBytes(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	return digest(keys, func(k string) []byte {
+		return data[k]
+	})
+}
+
+func digest(keys []string, value func(string) []byte) string {
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		// Prefix each with its length, so that the boundaries between
+		// keys and values are unambiguous.
+		write(h, []byte(k))
+		write(h, value(k))
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+func write(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// Subject identifies the snapshot whose digests are signed, so that a
+// signature cannot be copied onto another snapshot along with its content.
+type Subject struct {
+	Namespace string
+	Name      string
+	// SourceUID is the UID of the MutableMap the snapshot was taken of.
+	SourceUID string
+	// Generation is the generation of the MutableMap the snapshot holds.
+	Generation string
+}
+
+// SubjectOf returns the Subject of the provided snapshot, or of one of the
+// objects materializing it.
+func SubjectOf(obj metav1.Object) Subject {
+	return Subject{
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		SourceUID:  obj.GetAnnotations()[boos.SourceUIDAnnotation],
+		Generation: obj.GetLabels()[boos.GenerationLabelKey],
+	}
+}
+
+// Sign returns the signature of the subject's digests with the provided
+// key.
+func Sign(key []byte, subject Subject, digests ...string) string {
+	return signaturePrefix + hex.EncodeToString(mac(key, subject, digests))
+}
+
+// Verify checks that the signature is that of the subject's digests with
+// the provided key.
+func Verify(key []byte, signature string, subject Subject, digests ...string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, mac(key, subject, digests))
+}
+
+// mac returns the HMAC of the subject and its digests, each prefixed with
+// its length so that the boundaries between them are unambiguous.
+func mac(key []byte, subject Subject, digests []string) []byte {
+	m := hmac.New(sha256.New, key)
+	for _, s := range []string{subject.Namespace, subject.Name, subject.SourceUID, subject.Generation} {
+		write(m, []byte(s))
+	}
+	for _, d := range digests {
+		write(m, []byte(d))
+	}
+	return m.Sum(nil)
+}
+
+// Key is the key with which the digests of snapshots are signed.
+type Key struct {
+	// Data is the key itself.
+	Data []byte
+	// Created is when the key was generated.  Snapshots created before
+	// then predate signing.
+	Created time.Time
+}
+
+// Bytes returns the key itself, or nil when there is no key.
+func (k *Key) Bytes() []byte {
+	if k == nil {
+		return nil
+	}
+	return k.Data
+}
+
+// EnsureKey returns the signing key held in the provided namespace,
+// generating it if it does not exist.
+func EnsureKey(client kubernetes.Interface, namespace string) (*Key, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(KeyName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		key := make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		secret, err = client.CoreV1().Secrets(namespace).Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      KeyName,
+				Namespace: namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				KeyKey: key,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Secret %s/%s: %v", namespace, KeyName, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch Secret %s/%s: %v", namespace, KeyName, err)
+	}
+	key, ok := secret.Data[KeyKey]
+	if !ok || len(key) == 0 {
+		return nil, fmt.Errorf("Secret %s/%s has no key %q", namespace, KeyName, KeyKey)
+	}
+	return &Key{Data: key, Created: secret.CreationTimestamp.Time}, nil
+}
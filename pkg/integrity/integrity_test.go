@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrity
+
+import (
+	"testing"
+)
+
+var subject = Subject{
+	Namespace:  "default",
+	Name:       "my-config-abcd1234-00002",
+	SourceUID:  "abcd1234-5678",
+	Generation: "2",
+}
+
+func TestDigest(t *testing.T) {
+	data := map[string]string{"a": "1", "b": "2"}
+	if got, want := Digest(data), Digest(map[string]string{"b": "2", "a": "1"}); got != want {
+		t.Errorf("Digest() = %s, wanted %s regardless of order", got, want)
+	}
+	if got, want := Digest(data), DigestBytes(map[string][]byte{"a": []byte("1"), "b": []byte("2")}); got != want {
+		t.Errorf("Digest() = %s, wanted DigestBytes() = %s", got, want)
+	}
+	// Moving the boundary between a key and its value changes the digest.
+	if Digest(map[string]string{"ab": "c"}) == Digest(map[string]string{"a": "bc"}) {
+		t.Error("Digest() is ambiguous about the boundaries between keys and values")
+	}
+	if Digest(data) == Digest(map[string]string{"a": "1", "b": "3"}) {
+		t.Error("Digest() does not cover the values")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	digests := []string{Digest(map[string]string{"a": "1"}), DigestBytes(map[string][]byte{"password": []byte("hunter2")})}
+	sig := Sign(key, subject, digests...)
+
+	tests := []struct {
+		name      string
+		key       []byte
+		signature string
+		subject   Subject
+		digests   []string
+		want      bool
+	}{{
+		name:      "valid",
+		key:       key,
+		signature: sig,
+		subject:   subject,
+		digests:   digests,
+		want:      true,
+	}, {
+		name:      "wrong key",
+		key:       []byte("fedcba9876543210fedcba9876543210"),
+		signature: sig,
+		subject:   subject,
+		digests:   digests,
+	}, {
+		name:      "altered content",
+		key:       key,
+		signature: sig,
+		subject:   subject,
+		digests:   []string{Digest(map[string]string{"a": "2"}), digests[1]},
+	}, {
+		name:      "altered Secret",
+		key:       key,
+		signature: sig,
+		subject:   subject,
+		digests:   []string{digests[0], DigestBytes(map[string][]byte{"password": []byte("hunter3")})},
+	}, {
+		name:      "dropped Secret digest",
+		key:       key,
+		signature: sig,
+		subject:   subject,
+		digests:   digests[:1],
+	}, {
+		name:      "copied to another namespace",
+		key:       key,
+		signature: sig,
+		subject:   Subject{Namespace: "other", Name: subject.Name, SourceUID: subject.SourceUID, Generation: subject.Generation},
+		digests:   digests,
+	}, {
+		name:      "copied to another name",
+		key:       key,
+		signature: sig,
+		subject:   Subject{Namespace: subject.Namespace, Name: "my-config-abcd1234-00003", SourceUID: subject.SourceUID, Generation: subject.Generation},
+		digests:   digests,
+	}, {
+		name:      "copied from another source",
+		key:       key,
+		signature: sig,
+		subject:   Subject{Namespace: subject.Namespace, Name: subject.Name, SourceUID: "other-uid", Generation: subject.Generation},
+		digests:   digests,
+	}, {
+		name:      "relabelled generation",
+		key:       key,
+		signature: sig,
+		subject:   Subject{Namespace: subject.Namespace, Name: subject.Name, SourceUID: subject.SourceUID, Generation: "3"},
+		digests:   digests,
+	}, {
+		name:      "shifted boundary between fields",
+		key:       key,
+		signature: sig,
+		subject:   Subject{Namespace: "defaultmy-config-abcd1234-00002", SourceUID: subject.SourceUID, Generation: subject.Generation},
+		digests:   digests,
+	}, {
+		name:      "missing prefix",
+		key:       key,
+		signature: sig[len(signaturePrefix):],
+		subject:   subject,
+		digests:   digests,
+	}, {
+		name:      "not hex",
+		key:       key,
+		signature: signaturePrefix + "not-hex",
+		subject:   subject,
+		digests:   digests,
+	}, {
+		name:    "empty",
+		key:     key,
+		subject: subject,
+		digests: digests,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Verify(test.key, test.signature, test.subject, test.digests...); got != test.want {
+				t.Errorf("Verify() = %v, wanted %v", got, test.want)
+			}
+		})
+	}
+}
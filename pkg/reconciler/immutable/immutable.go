@@ -19,23 +19,36 @@ package immutable
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/kmp"
 	"github.com/knative/serving/pkg/reconciler"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
 	clientset "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
 	boosscheme "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/scheme"
 	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
 	listers "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/consumers"
+	"github.com/mattmoor/boo-maps/pkg/integrity"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/immutable/resources"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/immutable/resources/names"
 )
@@ -48,8 +61,51 @@ type Reconciler struct {
 
 	boosclientset clientset.Interface
 
+	// reportOnly, when set, makes the Reconciler report changes to the
+	// frozen ConfigMaps without reverting them.
+	reportOnly bool
+	// reported tracks the last resourceVersion of each ConfigMap whose drift
+	// was reported in reportOnly mode, keyed by UID, so that we report each
+	// change once.  Entries are removed when their ConfigMap is deleted.
+	reported sync.Map
+
+	// filter selects the annotations to propagate to ConfigMaps.
+	filter annotations.Filter
+	// signingKey, when set, is the key with which we verify the signatures
+	// of the digests of ImmutableMaps, and sign those of the ImmutableMaps
+	// created before it.
+	signingKey *integrity.Key
+
+	// lazy, when set, configures the Reconciler to only materialize the
+	// ConfigMaps of referenced snapshots.
+	lazy *Lazy
+	// enqueueAfter requeues the provided key after a delay.
+	enqueueAfter func(key interface{}, delay time.Duration)
+
 	immutableMapLister listers.ImmutableMapLister
 	configMapLister    corev1listers.ConfigMapLister
+	secretLister       corev1listers.SecretLister
+}
+
+// Lazy configures the Reconciler to materialize ConfigMaps only for the
+// snapshots that workloads reference.
+type Lazy struct {
+	// Consumers finds the workloads referencing snapshots.
+	Consumers *consumers.Lister
+
+	// MapTags observes the tags pointing at snapshots, which the webhook
+	// may resolve references to at any time, so tagged snapshots stay
+	// materialized.
+	MapTags informers.MapTagInformer
+
+	// MutableMaps observes the current generation of each MutableMap, to
+	// whose snapshot the webhook pins new references, so it stays
+	// materialized.
+	MutableMaps informers.MutableMapInformer
+
+	// GracePeriod is how long a snapshot must go unreferenced before its
+	// ConfigMap is removed.
+	GracePeriod time.Duration
 }
 
 // Check that we implement the controller.Reconciler interface.
@@ -67,15 +123,26 @@ func NewController(
 	boosclientset clientset.Interface,
 	immutableMapInformer informers.ImmutableMapInformer,
 	configMapInformer corev1informers.ConfigMapInformer,
+	secretInformer corev1informers.SecretInformer,
+	reportOnly bool,
+	filter annotations.Filter,
+	signingKey *integrity.Key,
+	lazy *Lazy,
 ) *controller.Impl {
 	r := &Reconciler{
 		Base:               reconciler.NewBase(opt, controllerAgentName),
 		boosclientset:      boosclientset,
+		reportOnly:         reportOnly,
+		filter:             filter,
+		signingKey:         signingKey,
+		lazy:               lazy,
 		immutableMapLister: immutableMapInformer.Lister(),
 		configMapLister:    configMapInformer.Lister(),
+		secretLister:       secretInformer.Lister(),
 	}
 	impl := controller.NewImpl(r, r.Logger, "ImmutableMaps",
 		reconciler.MustNewStatsReporter("ImmutableMaps", r.Logger))
+	r.enqueueAfter = impl.WorkQueue.AddAfter
 
 	r.Logger.Info("Setting up event handlers")
 
@@ -96,6 +163,110 @@ func NewController(
 		},
 	})
 
+	// Set up an event handler for when the Secrets of our snapshots change,
+	// so that we verify them.
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.Filter(v1alpha1.SchemeGroupVersion.WithKind("ImmutableMap")),
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    impl.EnqueueControllerOf,
+			UpdateFunc: controller.PassNew(impl.EnqueueControllerOf),
+			DeleteFunc: impl.EnqueueControllerOf,
+		},
+	})
+
+	// Forget the drift we reported on ConfigMaps that are deleted.
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: r.forgetDrift,
+	})
+
+	// ConfigMaps without a controller may have been re-created from under
+	// us, so enqueue the ImmutableMap they were materialized from to adopt
+	// them.
+	enqueueSnapshot := func(obj interface{}) {
+		cm := obj.(*corev1.ConfigMap)
+		impl.EnqueueKey(cm.Namespace + "/" + v1alpha1.SnapshotName(cm.Name))
+	}
+	configMapInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			cm, ok := obj.(*corev1.ConfigMap)
+			if !ok || metav1.GetControllerOf(cm) != nil {
+				return false
+			}
+			_, err := r.immutableMapLister.ImmutableMaps(cm.Namespace).Get(v1alpha1.SnapshotName(cm.Name))
+			return err == nil
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueueSnapshot,
+			UpdateFunc: controller.PassNew(enqueueSnapshot),
+		},
+	})
+
+	if lazy != nil {
+		// Set up an event handler for when the workloads referencing our
+		// snapshots change.
+		enqueue := consumers.EnqueueReferences(func(key string) {
+			namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+			if _, err := r.immutableMapLister.ImmutableMaps(namespace).Get(name); err == nil {
+				impl.EnqueueKey(key)
+			}
+		})
+		lazy.Consumers.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: enqueue,
+			UpdateFunc: func(old, new interface{}) {
+				// A workload pinned to a new snapshot may release the old one.
+				enqueue(old)
+				enqueue(new)
+			},
+			DeleteFunc: enqueue,
+		})
+
+		// enqueueGeneration enqueues the snapshot of the given generation
+		// of the named MutableMap.
+		enqueueGeneration := func(namespace, mutableMap string, generation int64) {
+			ims, err := r.immutableMapLister.ImmutableMaps(namespace).List(labels.SelectorFromSet(labels.Set{
+				boos.MutableMapLabelKey: v1alpha1.MutableMapLabel(mutableMap),
+				boos.GenerationLabelKey: strconv.FormatInt(generation, 10),
+			}))
+			if err != nil {
+				return
+			}
+			for _, im := range ims {
+				impl.Enqueue(im)
+			}
+		}
+
+		// Set up an event handler for when the tags pointing at our
+		// snapshots change.
+		enqueueTagged := func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if mt, ok := obj.(*v1alpha1.MapTag); ok {
+				enqueueGeneration(mt.Namespace, mt.MutableMap(), mt.Spec.Generation)
+			}
+		}
+		lazy.MapTags.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: enqueueTagged,
+			UpdateFunc: func(old, new interface{}) {
+				// A moved tag may release the snapshot it pointed at.
+				enqueueTagged(old)
+				enqueueTagged(new)
+			},
+			DeleteFunc: enqueueTagged,
+		})
+
+		// Set up an event handler for when a MutableMap moves on from a
+		// generation, whose snapshot may then be removed.  The snapshot of
+		// the new generation is enqueued when it is created.
+		lazy.MutableMaps.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				if mm, ok := old.(*v1alpha1.MutableMap); ok && mm.Generation != new.(*v1alpha1.MutableMap).Generation {
+					enqueueGeneration(mm.Namespace, mm.Name, mm.Generation)
+				}
+			},
+		})
+	}
+
 	return impl
 }
 
@@ -125,34 +296,443 @@ func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 }
 
 func (c *Reconciler) reconcile(ctx context.Context, im *v1alpha1.ImmutableMap) error {
-	if err := c.reconcileConfigMap(ctx, im); err != nil {
+	if verified, err := c.verify(im); err != nil {
+		return err
+	} else if !verified {
+		// Never materialize content that has been tampered with.
+		return nil
+	}
+	if c.lazy != nil {
+		return c.reconcileLazily(ctx, im)
+	}
+	if err := c.reconcileConfigMaps(ctx, im); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Reconciler) reconcileConfigMap(ctx context.Context, im *v1alpha1.ImmutableMap) error {
+// tagged returns whether a MapTag points at the snapshot.
+func (c *Reconciler) tagged(im *v1alpha1.ImmutableMap) (bool, error) {
+	mm, generation := im.SnapshotOf(), im.SnapshotGeneration()
+	if mm == "" || generation == 0 {
+		return false, nil
+	}
+	mts, err := c.lazy.MapTags.Lister().MapTags(im.Namespace).List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	for _, mt := range mts {
+		if mt.MutableMap() == mm && mt.Spec.Generation == generation {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// current returns whether the snapshot is of the current generation of its
+// MutableMap.
+func (c *Reconciler) current(im *v1alpha1.ImmutableMap) (bool, error) {
+	name := im.SnapshotOf()
+	if name == "" {
+		return false, nil
+	}
+	mm, err := c.lazy.MutableMaps.Lister().MutableMaps(im.Namespace).Get(name)
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return metav1.IsControlledBy(im, mm) && im.SnapshotGeneration() == mm.Generation, nil
+}
+
+// reconcileLazily materializes the ConfigMaps of the snapshot while it is
+// referenced, tagged or of the current generation of its MutableMap, or
+// within the grace period since it was last referenced, and removes them
+// once that has passed.
+func (c *Reconciler) reconcileLazily(ctx context.Context, im *v1alpha1.ImmutableMap) error {
 	cmName := names.ConfigMap(im)
-	cm, err := c.configMapLister.ConfigMaps(im.Namespace).Get(cmName)
+	referenced, err := c.lazy.Consumers.Referenced(im.Namespace, cmName)
+	if err != nil {
+		return err
+	}
+	if !referenced {
+		if referenced, err = c.tagged(im); err != nil {
+			return err
+		}
+	}
+	if referenced {
+		if err := c.reconcileConfigMaps(ctx, im); err != nil {
+			return err
+		}
+		if im.Status.UnreferencedSince != nil {
+			im.Status.UnreferencedSince = nil
+			return c.updateStatus(im)
+		}
+		return nil
+	}
+
+	if im.Status.UnreferencedSince == nil {
+		now := metav1.Now()
+		im.Status.UnreferencedSince = &now
+		if err := c.updateStatus(im); err != nil {
+			return err
+		}
+	}
+
+	// The webhook pins new references to the snapshot of the current
+	// generation once its ConfigMaps exist, so they must exist before
+	// anything references it.
+	if current, err := c.current(im); err != nil {
+		return err
+	} else if current {
+		return c.reconcileConfigMaps(ctx, im)
+	}
+	if remaining := c.lazy.GracePeriod - time.Since(im.Status.UnreferencedSince.Time); remaining > 0 {
+		// Keep the ConfigMaps intact for the remainder of the grace period.
+		c.enqueueAfter(im.Namespace+"/"+im.Name, remaining)
+		return c.reconcileConfigMaps(ctx, im)
+	}
+	for _, desired := range resources.MakeConfigMaps(im, c.filter) {
+		cm, err := c.configMapLister.ConfigMaps(im.Namespace).Get(desired.Name)
+		if apierrs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		} else if !metav1.IsControlledBy(cm, im) {
+			continue
+		}
+		if err := c.KubeClientSet.CoreV1().ConfigMaps(im.Namespace).Delete(cm.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &cm.UID},
+		}); err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		c.Recorder.Eventf(im, corev1.EventTypeNormal, "ConfigMapRemoved",
+			"Removed ConfigMap %q, which has not been referenced since %v", cm.Name, im.Status.UnreferencedSince.Time)
+	}
+	return nil
+}
+
+// verify checks the content of the ImmutableMap and of its Secret against
+// their digests, and the digests against their signature, which is
+// required when we sign snapshots, and records the outcome in its status.
+func (c *Reconciler) verify(im *v1alpha1.ImmutableMap) (bool, error) {
+	before := im.Status.DeepCopy()
+	im.Status.InitializeConditions()
+
+	pending, err := c.signLegacy(im)
+	if err != nil {
+		return false, err
+	}
+
+	verified := false
+	digest, hasDigest := im.Annotations[boos.DigestAnnotation]
+	digests := []string{digest}
+	secretDigest, hasSecretDigest := im.Annotations[boos.SecretDigestAnnotation]
+	if hasSecretDigest {
+		digests = append(digests, secretDigest)
+	}
+	sig, hasSig := im.Annotations[boos.SignatureAnnotation]
+	switch {
+	case pending:
+		im.Status.MarkVerificationUnknown("PendingSignature",
+			"The snapshot predates signing, and is signed once labelled with its generation")
+	case !hasDigest && c.signingKey == nil:
+		// Snapshots that predate digests cannot be verified, but may still
+		// be materialized unless we require signatures.
+		im.Status.MarkVerificationUnknown("MissingDigest",
+			"The snapshot has no digest to verify its content against")
+		verified = true
+	case !hasDigest:
+		im.Status.MarkVerificationFailed("MissingDigest",
+			"The snapshot has no digest, which is required to verify its signature")
+	case integrity.Digest(im.Spec) != digest:
+		im.Status.MarkVerificationFailed("DigestMismatch",
+			"The content digests to %s rather than %s", integrity.Digest(im.Spec), digest)
+	case c.signingKey != nil && !hasSig:
+		im.Status.MarkVerificationFailed("MissingSignature",
+			"The snapshot has no signature, which is required")
+	case c.signingKey != nil && !integrity.Verify(c.signingKey.Data, sig, integrity.SubjectOf(im), digests...):
+		im.Status.MarkVerificationFailed("SignatureMismatch",
+			"The digests do not match their signature")
+	default:
+		if ok, err := c.verifySecret(im, secretDigest, hasSecretDigest); err != nil {
+			return false, err
+		} else if ok {
+			im.Status.MarkVerified()
+			verified = true
+		}
+	}
+	cond := im.Status.GetCondition(v1alpha1.ImmutableMapConditionVerified)
+	if cond.Status == corev1.ConditionFalse {
+		c.Logger.Errorf("Verification of ImmutableMap %s/%s failed: %s", im.Namespace, im.Name, cond.Message)
+		// Only record the failure when it changes, rather than on every
+		// resync.
+		if prev := before.GetCondition(v1alpha1.ImmutableMapConditionVerified); prev == nil ||
+			prev.Status != cond.Status || prev.Reason != cond.Reason {
+			c.Recorder.Eventf(im, corev1.EventTypeWarning, cond.Reason, "Verification failed: %s", cond.Message)
+		}
+	}
+
+	if equality.Semantic.DeepEqual(before, &im.Status) {
+		return verified, nil
+	}
+	return verified, c.updateStatus(im)
+}
+
+// verifySecret checks the data of the snapshot's Secret against its
+// digest, marking the ImmutableMap when it does not match.  The Secret of
+// a snapshot whose digest is recorded must exist.
+func (c *Reconciler) verifySecret(im *v1alpha1.ImmutableMap, digest string, hasDigest bool) (bool, error) {
+	if !hasDigest {
+		if _, ok := im.SecretKeys(); ok && c.signingKey != nil {
+			im.Status.MarkVerificationFailed("MissingSecretDigest",
+				"The snapshot has no digest of its Secret, which is required to verify it")
+			return false, nil
+		}
+		// Snapshots without a Secret, or that predate the digests of
+		// their Secrets.
+		return true, nil
+	}
+	secret, err := c.secretLister.Secrets(im.Namespace).Get(im.Name)
 	if apierrs.IsNotFound(err) {
-		desiredCM := resources.MakeConfigMap(im)
-		cm, err = c.KubeClientSet.CoreV1().ConfigMaps(im.Namespace).Create(desiredCM)
+		im.Status.MarkVerificationFailed("MissingSecret",
+			"The Secret %q whose digest the snapshot records does not exist", im.Name)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if got := integrity.DigestBytes(secret.Data); got != digest {
+		im.Status.MarkVerificationFailed("SecretDigestMismatch",
+			"The data of Secret %q digests to %s rather than %s", secret.Name, got, digest)
+		return false, nil
+	}
+	return true, nil
+}
+
+// signLegacy signs the digests of a snapshot created before the signing
+// key, which we therefore never signed, first recording the digests it
+// predates.  This happens once: snapshots created since then must already
+// be signed, and the signature may never be changed.  It returns whether
+// the snapshot must first be labelled with its generation, which the
+// signature covers.
+func (c *Reconciler) signLegacy(im *v1alpha1.ImmutableMap) (bool, error) {
+	if c.signingKey == nil || !im.CreationTimestamp.Time.Before(c.signingKey.Created) {
+		return false, nil
+	}
+	if _, ok := im.Annotations[boos.SignatureAnnotation]; ok {
+		return false, nil
+	}
+	if _, ok := im.Labels[boos.GenerationLabelKey]; !ok {
+		// The MutableMap's reconciler backfills the label.
+		return true, nil
+	}
+
+	annotations := make(map[string]string, 3)
+	digest, ok := im.Annotations[boos.DigestAnnotation]
+	if !ok {
+		digest = integrity.Digest(im.Spec)
+		annotations[boos.DigestAnnotation] = digest
+	}
+	digests := []string{digest}
+	if secretDigest, ok := im.Annotations[boos.SecretDigestAnnotation]; ok {
+		digests = append(digests, secretDigest)
+	} else if _, ok := im.SecretKeys(); ok {
+		secret, err := c.secretLister.Secrets(im.Namespace).Get(im.Name)
+		if apierrs.IsNotFound(err) {
+			// There is nothing to sign, so verification fails.
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		secretDigest = integrity.DigestBytes(secret.Data)
+		annotations[boos.SecretDigestAnnotation] = secretDigest
+		digests = append(digests, secretDigest)
+	}
+	annotations[boos.SignatureAnnotation] = integrity.Sign(c.signingKey.Data, integrity.SubjectOf(im), digests...)
+
+	desired := im.DeepCopy()
+	if desired.Annotations == nil {
+		desired.Annotations = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		desired.Annotations[k] = v
+	}
+	updated, err := c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Update(desired)
+	if err != nil {
+		return false, err
+	}
+	im.ObjectMeta = updated.ObjectMeta
+	c.Recorder.Eventf(im, corev1.EventTypeNormal, "Signed",
+		"Signed ImmutableMap %q, which predates signing", im.Name)
+	return false, nil
+}
+
+// updateStatus writes the status of the ImmutableMap, and updates its
+// resourceVersion so that it may be written again.
+func (c *Reconciler) updateStatus(im *v1alpha1.ImmutableMap) error {
+	updated, err := c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).UpdateStatus(im)
+	if err != nil {
+		return err
+	}
+	im.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// reconcileConfigMaps materializes each of the ConfigMaps of the
+// ImmutableMap, of which there are several when its content is sharded.
+func (c *Reconciler) reconcileConfigMaps(ctx context.Context, im *v1alpha1.ImmutableMap) error {
+	for _, desired := range resources.MakeConfigMaps(im, c.filter) {
+		if err := c.reconcileConfigMap(ctx, im, desired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Reconciler) reconcileConfigMap(ctx context.Context, im *v1alpha1.ImmutableMap, desired *corev1.ConfigMap) error {
+	cm, err := c.configMapLister.ConfigMaps(im.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		cm, err = c.KubeClientSet.CoreV1().ConfigMaps(im.Namespace).Create(desired)
 		if err != nil {
 			return err
 		}
 	} else if err != nil {
 		return err
 	} else {
-		desiredCM := resources.MakeConfigMap(im)
-		if !equality.Semantic.DeepEqual(cm.Data, desiredCM.Data) {
-			cm = cm.DeepCopy()
-			cm.Data = desiredCM.Data
-			cm, err = c.KubeClientSet.CoreV1().ConfigMaps(im.Namespace).Update(cm)
+		want, err := desiredState(im, cm, desired)
+		if err != nil {
+			c.Recorder.Eventf(im, corev1.EventTypeWarning, "NotOwned", "%v", err)
+			return err
+		}
+		if !equality.Semantic.DeepEqual(owned(want), owned(cm)) {
+			diff, err := kmp.SafeDiff(owned(want), owned(cm))
 			if err != nil {
+				return fmt.Errorf("failed to diff ConfigMap: %v", err)
+			}
+			if c.reportOnly {
+				c.reportDrift(im, cm, diff)
+				return nil
+			}
+			if _, err := c.KubeClientSet.CoreV1().ConfigMaps(im.Namespace).Update(want); err != nil {
 				return err
 			}
+			c.revertedDrift(im, cm, diff)
 		}
 	}
 
 	return nil
 }
+
+// desiredState returns a copy of the existing ConfigMap updated with the
+// fields of the desired ConfigMap that we own.  Labels and annotations
+// that we do not set are left to whoever did, but a ConfigMap without a
+// controller is adopted, and one controlled by anything other than the
+// ImmutableMap is refused.
+func desiredState(im *v1alpha1.ImmutableMap, cm, desired *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if owner := metav1.GetControllerOf(cm); owner != nil && owner.UID != im.UID {
+		return nil, fmt.Errorf("ConfigMap %q is controlled by %s %q, not ImmutableMap %q",
+			cm.Name, owner.Kind, owner.Name, im.Name)
+	}
+	want := cm.DeepCopy()
+	if !metav1.IsControlledBy(cm, im) {
+		want.OwnerReferences = append(want.OwnerReferences, desired.OwnerReferences...)
+	}
+	want.Labels = merge(want.Labels, desired.Labels)
+	want.Annotations = merge(want.Annotations, desired.Annotations)
+	want.Data = desired.Data
+	want.BinaryData = desired.BinaryData
+	return want, nil
+}
+
+// merge returns a copy of base overlaid with the entries of overlay.
+func merge(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	out := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range overlay {
+		out[k] = v
+	}
+	return out
+}
+
+// ownedFields are the fields of a ConfigMap we compare against the desired
+// state, excluding the bookkeeping (e.g. timestamps) of the API server.
+type ownedFields struct {
+	Labels          map[string]string
+	Annotations     map[string]string
+	OwnerReferences []metav1.OwnerReference
+	Data            map[string]string
+	BinaryData      map[string][]byte
+}
+
+func owned(cm *corev1.ConfigMap) ownedFields {
+	return ownedFields{
+		Labels:          cm.Labels,
+		Annotations:     cm.Annotations,
+		OwnerReferences: cm.OwnerReferences,
+		Data:            cm.Data,
+		BinaryData:      cm.BinaryData,
+	}
+}
+
+// reportDrift surfaces changes to the frozen ConfigMap that we have been
+// configured not to revert.
+func (c *Reconciler) reportDrift(im *v1alpha1.ImmutableMap, cm *corev1.ConfigMap, diff string) {
+	if rv, ok := c.reported.Load(cm.UID); ok && rv == cm.ResourceVersion {
+		// Already reported.
+		return
+	}
+	c.reported.Store(cm.UID, cm.ResourceVersion)
+	c.Logger.Warnf("Detected changes to ConfigMap %s/%s (-want +got): %s", cm.Namespace, cm.Name, diff)
+	for _, obj := range []kruntime.Object{im, cm} {
+		c.Recorder.Eventf(obj, corev1.EventTypeWarning, "DriftDetected",
+			"Detected changes to ConfigMap %q (-want +got): %s", cm.Name, truncate(diff))
+	}
+	if err := reportDrift(cm.Namespace, false); err != nil {
+		c.Logger.Errorf("Failed to report drift metric: %v", err)
+	}
+}
+
+// revertedDrift surfaces changes to the frozen ConfigMap that we reverted.
+func (c *Reconciler) revertedDrift(im *v1alpha1.ImmutableMap, cm *corev1.ConfigMap, diff string) {
+	c.Logger.Warnf("Reverted changes to ConfigMap %s/%s (-want +got): %s", cm.Namespace, cm.Name, diff)
+	for _, obj := range []kruntime.Object{im, cm} {
+		c.Recorder.Eventf(obj, corev1.EventTypeWarning, "DriftReverted",
+			"Reverted changes to ConfigMap %q (-want +got): %s", cm.Name, truncate(diff))
+	}
+	if err := reportDrift(cm.Namespace, true); err != nil {
+		c.Logger.Errorf("Failed to report drift metric: %v", err)
+	}
+}
+
+// forgetDrift removes the record of the drift reported on a ConfigMap
+// that has been deleted.
+func (c *Reconciler) forgetDrift(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		c.reported.Delete(cm.UID)
+	}
+}
+
+// maxEventDiff bounds the length of the diffs we include in events, which
+// would otherwise grow with the content of the ConfigMap.  The full diff is
+// logged.
+const maxEventDiff = 1024
+
+// truncate shortens the diff to at most maxEventDiff bytes.
+func truncate(diff string) string {
+	if len(diff) <= maxEventDiff {
+		return diff
+	}
+	cut := maxEventDiff
+	for cut > 0 && !utf8.RuneStart(diff[cut]) {
+		cut--
+	}
+	return diff[:cut] + "... (truncated, see the controller's logs)"
+}
@@ -0,0 +1,473 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immutable
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/knative/pkg/kmeta"
+	"github.com/knative/serving/pkg/reconciler"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/fake"
+	"github.com/mattmoor/boo-maps/pkg/integrity"
+	rtesting "github.com/mattmoor/boo-maps/pkg/reconciler/testing"
+)
+
+const (
+	testNamespace   = "default"
+	testMutableMap  = "foo"
+	testGracePeriod = time.Hour
+)
+
+func mutableMap(generation int64) *v1alpha1.MutableMap {
+	return &v1alpha1.MutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  testNamespace,
+			Name:       testMutableMap,
+			UID:        types.UID("mm-uid"),
+			Generation: generation,
+		},
+	}
+}
+
+type snapshotOption func(*v1alpha1.ImmutableMap)
+
+// unreferencedFor marks the snapshot as unreferenced for the duration.
+func unreferencedFor(d time.Duration) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		since := metav1.NewTime(time.Now().Add(-d))
+		im.Status.UnreferencedSince = &since
+	}
+}
+
+func snapshot(generation int64, opts ...snapshotOption) *v1alpha1.ImmutableMap {
+	im := &v1alpha1.ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      fmt.Sprintf("%s-%05d", testMutableMap, generation),
+			UID:       types.UID(fmt.Sprintf("im-uid-%d", generation)),
+			Labels: map[string]string{
+				boos.MutableMapLabelKey: testMutableMap,
+				boos.GenerationLabelKey: fmt.Sprint(generation),
+			},
+			Annotations: map[string]string{
+				boos.MutableMapAnnotationKey: testMutableMap,
+			},
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(mutableMap(generation))},
+		},
+		Spec: map[string]string{"key": "value"},
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+	return im
+}
+
+// createdAt sets when the snapshot was created.
+func createdAt(t time.Time) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		im.CreationTimestamp = metav1.NewTime(t)
+	}
+}
+
+// withDigests records the digests of the snapshot's content and, when
+// provided, of the data of its Secret.
+func withDigests(secretData map[string][]byte) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		im.Annotations[boos.DigestAnnotation] = integrity.Digest(im.Spec)
+		if secretData != nil {
+			im.Annotations[boos.SecretKeysAnnotation] = "password"
+			im.Annotations[boos.SecretDigestAnnotation] = integrity.DigestBytes(secretData)
+		}
+	}
+}
+
+// signedAs signs the recorded digests of the snapshot as those of the
+// subject.
+func signedAs(key []byte, subject integrity.Subject) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		digests := []string{im.Annotations[boos.DigestAnnotation]}
+		if d, ok := im.Annotations[boos.SecretDigestAnnotation]; ok {
+			digests = append(digests, d)
+		}
+		im.Annotations[boos.SignatureAnnotation] = integrity.Sign(key, subject, digests...)
+	}
+}
+
+// signed signs the recorded digests of the snapshot.
+func signed(key []byte) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		signedAs(key, integrity.SubjectOf(im))(im)
+	}
+}
+
+// withSpec replaces the content of the snapshot.
+func withSpec(spec map[string]string) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		im.Spec = spec
+	}
+}
+
+// withoutLabel removes a label of the snapshot.
+func withoutLabel(key string) snapshotOption {
+	return func(im *v1alpha1.ImmutableMap) {
+		delete(im.Labels, key)
+	}
+}
+
+func mapTag(name string, generation int64) *v1alpha1.MapTag {
+	return &v1alpha1.MapTag{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      v1alpha1.TagName(testMutableMap, name),
+		},
+		Spec: v1alpha1.MapTagSpec{Generation: generation},
+	}
+}
+
+func deployment(configMap string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      "app",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// configMap returns the ConfigMap materialized from the snapshot.
+func configMap(im *v1alpha1.ImmutableMap) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       im.Namespace,
+			Name:            im.Name,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(im)},
+		},
+		Data: im.Spec,
+	}
+}
+
+// secret returns the Secret of the snapshot, holding the provided data.
+func secret(im *v1alpha1.ImmutableMap, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       im.Namespace,
+			Name:            im.Name,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(im)},
+		},
+		Data: data,
+	}
+}
+
+// newTestReconciler returns a lazy Reconciler observing the provided
+// objects, along with the client through which it manages ConfigMaps.
+func newTestReconciler(objects ...runtime.Object) (*Reconciler, *rtesting.KubeClient) {
+	var kubeObjects, boosObjects []runtime.Object
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *corev1.ConfigMap, *corev1.Secret:
+			kubeObjects = append(kubeObjects, obj)
+		case *v1alpha1.MutableMap, *v1alpha1.ImmutableMap, *v1alpha1.MapTag:
+			boosObjects = append(boosObjects, obj)
+		}
+	}
+	kubeClient := rtesting.NewKubeClient(kubeObjects...)
+	informers := rtesting.NewInformers(objects...)
+	return &Reconciler{
+		Base: &reconciler.Base{
+			KubeClientSet: kubeClient,
+			Recorder:      record.NewFakeRecorder(100),
+			Logger:        zap.NewNop().Sugar(),
+		},
+		boosclientset: fake.NewSimpleClientset(boosObjects...),
+		lazy: &Lazy{
+			Consumers:   informers.Consumers(),
+			MapTags:     informers.Boos.Boos().V1alpha1().MapTags(),
+			MutableMaps: informers.Boos.Boos().V1alpha1().MutableMaps(),
+			GracePeriod: testGracePeriod,
+		},
+		enqueueAfter:       func(interface{}, time.Duration) {},
+		immutableMapLister: informers.Boos.Boos().V1alpha1().ImmutableMaps().Lister(),
+		configMapLister:    informers.Kube.Core().V1().ConfigMaps().Lister(),
+		secretLister:       informers.Kube.Core().V1().Secrets().Lister(),
+	}, kubeClient
+}
+
+func TestReconcileLazily(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		im      *v1alpha1.ImmutableMap
+		want    bool
+	}{{
+		name:    "new snapshot of the current generation, no consumers",
+		objects: []runtime.Object{mutableMap(2)},
+		im:      snapshot(2),
+		want:    true,
+	}, {
+		name:    "new snapshot of a superseded generation, no consumers",
+		objects: []runtime.Object{mutableMap(3)},
+		im:      snapshot(2),
+		want:    true,
+	}, {
+		name:    "current generation unreferenced past the grace period",
+		objects: []runtime.Object{mutableMap(2)},
+		im:      snapshot(2, unreferencedFor(2*testGracePeriod)),
+		want:    true,
+	}, {
+		name:    "superseded generation unreferenced within the grace period",
+		objects: []runtime.Object{mutableMap(3), configMap(snapshot(2))},
+		im:      snapshot(2, unreferencedFor(testGracePeriod/2)),
+		want:    true,
+	}, {
+		name:    "superseded generation unreferenced past the grace period",
+		objects: []runtime.Object{mutableMap(3), configMap(snapshot(2))},
+		im:      snapshot(2, unreferencedFor(2*testGracePeriod)),
+		want:    false,
+	}, {
+		name:    "superseded generation referenced by a Deployment",
+		objects: []runtime.Object{mutableMap(3), deployment(snapshot(2).Name)},
+		im:      snapshot(2, unreferencedFor(2*testGracePeriod)),
+		want:    true,
+	}, {
+		name:    "superseded generation tagged",
+		objects: []runtime.Object{mutableMap(3), mapTag("stable", 2)},
+		im:      snapshot(2, unreferencedFor(2*testGracePeriod)),
+		want:    true,
+	}, {
+		name:    "superseded generation of a deleted MutableMap",
+		objects: []runtime.Object{configMap(snapshot(2))},
+		im:      snapshot(2, unreferencedFor(2*testGracePeriod)),
+		want:    false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, kubeClient := newTestReconciler(append(test.objects, test.im)...)
+			if err := c.Reconcile(context.Background(), test.im.Namespace+"/"+test.im.Name); err != nil {
+				t.Fatalf("Reconcile() = %v", err)
+			}
+
+			_, err := kubeClient.CoreV1().ConfigMaps(test.im.Namespace).Get(test.im.Name, metav1.GetOptions{})
+			if got := err == nil; got != test.want {
+				t.Errorf("ConfigMap exists = %v (%v), wanted %v", got, err, test.want)
+			} else if err != nil && !apierrs.IsNotFound(err) {
+				t.Errorf("Get() = %v", err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	keyCreated := time.Now().Add(-time.Hour)
+	key := &integrity.Key{Data: []byte("0123456789abcdef0123456789abcdef"), Created: keyCreated}
+	after := createdAt(keyCreated.Add(time.Minute))
+	before := createdAt(keyCreated.Add(-time.Minute))
+	data := map[string][]byte{"password": []byte("hunter2")}
+
+	tests := []struct {
+		name       string
+		key        *integrity.Key
+		objects    []runtime.Object
+		im         *v1alpha1.ImmutableMap
+		want       corev1.ConditionStatus
+		wantReason string
+	}{{
+		name:       "no digest",
+		im:         snapshot(2),
+		want:       corev1.ConditionUnknown,
+		wantReason: "MissingDigest",
+	}, {
+		name: "digest",
+		im:   snapshot(2, withDigests(nil)),
+		want: corev1.ConditionTrue,
+	}, {
+		name:       "altered content",
+		im:         snapshot(2, withDigests(nil), withSpec(map[string]string{"key": "other"})),
+		want:       corev1.ConditionFalse,
+		wantReason: "DigestMismatch",
+	}, {
+		name:    "Secret",
+		objects: []runtime.Object{secret(snapshot(2), data)},
+		im:      snapshot(2, withDigests(data)),
+		want:    corev1.ConditionTrue,
+	}, {
+		name:       "altered Secret",
+		objects:    []runtime.Object{secret(snapshot(2), map[string][]byte{"password": []byte("hunter3")})},
+		im:         snapshot(2, withDigests(data)),
+		want:       corev1.ConditionFalse,
+		wantReason: "SecretDigestMismatch",
+	}, {
+		name:       "missing Secret",
+		im:         snapshot(2, withDigests(data)),
+		want:       corev1.ConditionFalse,
+		wantReason: "MissingSecret",
+	}, {
+		name: "signed",
+		key:  key,
+		im:   snapshot(2, after, withDigests(nil), signed(key.Data)),
+		want: corev1.ConditionTrue,
+	}, {
+		name:    "signed with Secret",
+		key:     key,
+		objects: []runtime.Object{secret(snapshot(2), data)},
+		im:      snapshot(2, after, withDigests(data), signed(key.Data)),
+		want:    corev1.ConditionTrue,
+	}, {
+		name:       "signed, altered content",
+		key:        key,
+		im:         snapshot(2, after, withDigests(nil), signed(key.Data), withSpec(map[string]string{"key": "other"})),
+		want:       corev1.ConditionFalse,
+		wantReason: "DigestMismatch",
+	}, {
+		name:       "signed, altered Secret",
+		key:        key,
+		objects:    []runtime.Object{secret(snapshot(2), map[string][]byte{"password": []byte("hunter3")})},
+		im:         snapshot(2, after, withDigests(data), signed(key.Data)),
+		want:       corev1.ConditionFalse,
+		wantReason: "SecretDigestMismatch",
+	}, {
+		name:       "signed by another key",
+		key:        key,
+		im:         snapshot(2, after, withDigests(nil), signed([]byte("fedcba9876543210fedcba9876543210"))),
+		want:       corev1.ConditionFalse,
+		wantReason: "SignatureMismatch",
+	}, {
+		name: "signature copied from another snapshot",
+		key:  key,
+		im: snapshot(2, after, withDigests(nil), signedAs(key.Data, integrity.Subject{
+			Namespace:  testNamespace,
+			Name:       snapshot(3).Name,
+			Generation: "3",
+		})),
+		want:       corev1.ConditionFalse,
+		wantReason: "SignatureMismatch",
+	}, {
+		name:       "relabelled generation",
+		key:        key,
+		im:         snapshot(2, after, withDigests(nil), signed(key.Data), func(im *v1alpha1.ImmutableMap) { im.Labels[boos.GenerationLabelKey] = "3" }),
+		want:       corev1.ConditionFalse,
+		wantReason: "SignatureMismatch",
+	}, {
+		name:       "unsigned since signing",
+		key:        key,
+		im:         snapshot(2, after, withDigests(nil)),
+		want:       corev1.ConditionFalse,
+		wantReason: "MissingSignature",
+	}, {
+		name:       "signed without the digest of its Secret",
+		key:        key,
+		objects:    []runtime.Object{secret(snapshot(2), data)},
+		im:         snapshot(2, after, withDigests(nil), signed(key.Data), func(im *v1alpha1.ImmutableMap) { im.Annotations[boos.SecretKeysAnnotation] = "password" }),
+		want:       corev1.ConditionFalse,
+		wantReason: "MissingSecretDigest",
+	}, {
+		name: "predates signing",
+		key:  key,
+		im:   snapshot(2, before, withDigests(nil)),
+		want: corev1.ConditionTrue,
+	}, {
+		name: "predates digests and signing",
+		key:  key,
+		im:   snapshot(2, before),
+		want: corev1.ConditionTrue,
+	}, {
+		name:    "predates digests of Secrets and signing",
+		key:     key,
+		objects: []runtime.Object{secret(snapshot(2), data)},
+		im:      snapshot(2, before, func(im *v1alpha1.ImmutableMap) { im.Annotations[boos.SecretKeysAnnotation] = "password" }),
+		want:    corev1.ConditionTrue,
+	}, {
+		name:       "predates signing and its generation label",
+		key:        key,
+		im:         snapshot(2, before, withDigests(nil), withoutLabel(boos.GenerationLabelKey)),
+		want:       corev1.ConditionUnknown,
+		wantReason: "PendingSignature",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestReconciler(append(test.objects, test.im)...)
+			c.signingKey = test.key
+			im := test.im.DeepCopy()
+			verified, err := c.verify(im)
+			if err != nil {
+				t.Fatalf("verify() = %v", err)
+			}
+			if want := test.want != corev1.ConditionFalse && test.wantReason != "PendingSignature"; verified != want {
+				t.Errorf("verify() = %v, wanted %v", verified, want)
+			}
+			cond := im.Status.GetCondition(v1alpha1.ImmutableMapConditionVerified)
+			if cond.Status != test.want || cond.Reason != test.wantReason {
+				t.Errorf("Verified = %s (%s: %s), wanted %s (%s)", cond.Status, cond.Reason, cond.Message, test.want, test.wantReason)
+			}
+
+			// Verified snapshots that predate signing have been signed.
+			if test.key != nil && test.want == corev1.ConditionTrue {
+				got, err := c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Get(im.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Get() = %v", err)
+				}
+				if _, ok := got.Annotations[boos.SignatureAnnotation]; !ok {
+					t.Error("ImmutableMap was not signed")
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyReportsFailureOnce(t *testing.T) {
+	im := snapshot(2, withDigests(nil), withSpec(map[string]string{"key": "other"}))
+	c, _ := newTestReconciler(im)
+	recorder := c.Recorder.(*record.FakeRecorder)
+
+	im = im.DeepCopy()
+	for i := 0; i < 3; i++ {
+		if verified, err := c.verify(im); err != nil || verified {
+			t.Fatalf("verify() = %v, %v, wanted false", verified, err)
+		}
+	}
+	if got := len(recorder.Events); got != 1 {
+		t.Errorf("recorded %d events, wanted 1", got)
+	}
+}
@@ -21,18 +21,57 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/immutable/resources/names"
 )
 
-func MakeConfigMap(im *v1alpha1.ImmutableMap) *corev1.ConfigMap {
+// MakeConfigMaps returns the ConfigMaps materializing the ImmutableMap,
+// which is a single ConfigMap of the same name unless its content must be
+// split into shards to fit.
+func MakeConfigMaps(im *v1alpha1.ImmutableMap, filter annotations.Filter) []*corev1.ConfigMap {
+	shards := im.Shards()
+	if len(shards) == 1 {
+		return []*corev1.ConfigMap{makeConfigMap(im, filter, names.ConfigMap(im), im.Spec)}
+	}
+	cms := make([]*corev1.ConfigMap, 0, len(shards))
+	for i, keys := range shards {
+		data := make(map[string]string, len(keys))
+		for _, k := range keys {
+			data[k] = im.Spec[k]
+		}
+		cms = append(cms, makeConfigMap(im, filter, names.Shard(im, i), data))
+	}
+	return cms
+}
+
+func makeConfigMap(im *v1alpha1.ImmutableMap, filter annotations.Filter, name string, data map[string]string) *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:            names.ConfigMap(im),
+			Name:            name,
 			Namespace:       im.Namespace,
 			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(im)},
-			Annotations:     im.ObjectMeta.Annotations,
+			Labels:          im.ObjectMeta.Labels,
+			Annotations:     makeAnnotations(im, filter),
 		},
-		Data: im.Spec,
+		Data: data,
+	}
+}
+
+// makeAnnotations returns the annotations of the ImmutableMap that pass the
+// filter, plus its digest and signature regardless of the filter.
+func makeAnnotations(im *v1alpha1.ImmutableMap, filter annotations.Filter) map[string]string {
+	annotations := filter.Apply(im.Annotations)
+	for _, key := range []string{boos.DigestAnnotation, boos.SignatureAnnotation} {
+		v, ok := im.Annotations[key]
+		if !ok {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string, 2)
+		}
+		annotations[key] = v
 	}
+	return annotations
 }
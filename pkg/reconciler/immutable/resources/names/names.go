@@ -24,3 +24,9 @@ import (
 func ConfigMap(im *v1alpha1.ImmutableMap) string {
 	return im.Name
 }
+
+// Shard returns the name of the ConfigMap holding the i-th shard of the
+// ImmutableMap, when its content is split across several.
+func Shard(im *v1alpha1.ImmutableMap, i int) string {
+	return v1alpha1.ShardName(ConfigMap(im), i)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immutable
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/knative/pkg/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/mattmoor/boo-maps/pkg/reconciler/tags"
+)
+
+var (
+	driftCountStat = stats.Int64("configmap_drift_count",
+		"Number of changes to frozen ConfigMaps detected", stats.UnitNone)
+
+	revertedTagKey = tags.MustNewKey("reverted")
+)
+
+func init() {
+	err := view.Register(&view.View{
+		Description: "Number of changes to frozen ConfigMaps detected",
+		Measure:     driftCountStat,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{tags.Namespace, revertedTagKey},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// reportDrift records that a change to a frozen ConfigMap in the given
+// namespace was detected, and whether it was reverted.
+func reportDrift(namespace string, reverted bool) error {
+	ctx, err := tag.New(
+		context.Background(),
+		tag.Insert(tags.Namespace, namespace),
+		tag.Insert(revertedTagKey, strconv.FormatBool(reverted)))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, driftCountStat.M(1))
+	return nil
+}
@@ -18,27 +18,50 @@ package mutable
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/kmeta"
+	"github.com/knative/pkg/system"
 	"github.com/knative/serving/pkg/reconciler"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
 	clientset "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
 	boosscheme "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/scheme"
 	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
 	listers "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/consumers"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources"
 	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources/names"
+	"github.com/mattmoor/boo-maps/pkg/sealing"
 )
 
-const controllerAgentName = "mutable-controller"
+const (
+	controllerAgentName = "mutable-controller"
+
+	// stalenessInterval is how often we refresh the staleness reported for
+	// MutableMaps with consumers pinned to superseded snapshots.
+	stalenessInterval = time.Minute
+)
 
 // Reconciler is the controller implementation for Filter resources
 type Reconciler struct {
@@ -46,8 +69,24 @@ type Reconciler struct {
 
 	boosclientset clientset.Interface
 
+	// filter selects the annotations to propagate to ImmutableMaps.
+	filter annotations.Filter
+	// signingKey, when set, is the key with which we sign the digests of
+	// ImmutableMaps.
+	signingKey []byte
+
+	// consumerLister finds the consumers whose staleness we report, and
+	// that block deletion of a MutableMap under DeletionPolicyBlock.
+	consumerLister v1alpha1.ConsumerLister
+	// enqueueAfter requeues the provided key after a delay.
+	enqueueAfter func(key interface{}, delay time.Duration)
+	// staleness reports how far the consumers of each MutableMap lag.
+	staleness stalenessReporter
+
 	mutableMapLister   listers.MutableMapLister
 	immutableMapLister listers.ImmutableMapLister
+	// secretLister observes the Secrets of our snapshots only.
+	secretLister corev1listers.SecretLister
 }
 
 // Check that we implement the controller.Reconciler interface.
@@ -65,15 +104,24 @@ func NewController(
 	boosclientset clientset.Interface,
 	mutableMapInformer informers.MutableMapInformer,
 	immutableMapInformer informers.ImmutableMapInformer,
+	secretInformer corev1informers.SecretInformer,
+	filter annotations.Filter,
+	signingKey []byte,
+	consumerLister *consumers.Lister,
 ) *controller.Impl {
 	r := &Reconciler{
 		Base:               reconciler.NewBase(opt, controllerAgentName),
 		boosclientset:      boosclientset,
+		filter:             filter,
+		signingKey:         signingKey,
+		consumerLister:     consumerLister,
 		mutableMapLister:   mutableMapInformer.Lister(),
 		immutableMapLister: immutableMapInformer.Lister(),
+		secretLister:       secretInformer.Lister(),
 	}
 	impl := controller.NewImpl(r, r.Logger, "MutableMaps",
 		reconciler.MustNewStatsReporter("MutableMaps", r.Logger))
+	r.enqueueAfter = impl.WorkQueue.AddAfter
 
 	r.Logger.Info("Setting up event handlers")
 
@@ -94,6 +142,53 @@ func NewController(
 		},
 	})
 
+	// enqueueSnapshotController enqueues the MutableMap controlling the
+	// snapshot that controls the provided object.
+	enqueueSnapshotController := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		object, ok := obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		owner := metav1.GetControllerOf(object)
+		if owner == nil {
+			return
+		}
+		if im, err := r.immutableMapLister.ImmutableMaps(object.GetNamespace()).Get(owner.Name); err == nil {
+			impl.EnqueueControllerOf(im)
+		}
+	}
+
+	// Set up an event handler for when the Secrets of our snapshots change.
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.Filter(v1alpha1.SchemeGroupVersion.WithKind("ImmutableMap")),
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueueSnapshotController,
+			UpdateFunc: controller.PassNew(enqueueSnapshotController),
+			DeleteFunc: enqueueSnapshotController,
+		},
+	})
+
+	// Set up an event handler for when the workloads consuming our
+	// snapshots change, so that we may report their staleness.
+	enqueue := consumers.EnqueueReferences(func(key string) {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		if im, err := r.immutableMapLister.ImmutableMaps(namespace).Get(name); err == nil {
+			impl.EnqueueControllerOf(im)
+		}
+	})
+	consumerLister.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			// A workload re-pinned to a new snapshot leaves the old one.
+			enqueue(old)
+			enqueue(new)
+		},
+		DeleteFunc: enqueue,
+	})
+
 	return impl
 }
 
@@ -111,6 +206,11 @@ func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 	if errors.IsNotFound(err) {
 		// The MutableMap resource may no longer exist, in which case we stop processing.
 		runtime.HandleError(fmt.Errorf("filter %q in work queue no longer exists", key))
+		// MutableMaps deleted without our finalizer are not finalized, so
+		// reset their staleness here.
+		if err := c.staleness.forget(namespace, name); err != nil {
+			c.Logger.Errorf("Failed to report staleness: %v", err)
+		}
 		return nil
 	} else if err != nil {
 		return err
@@ -123,34 +223,473 @@ func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 }
 
 func (c *Reconciler) reconcile(ctx context.Context, im *v1alpha1.MutableMap) error {
+	if im.DeletionTimestamp != nil {
+		return c.finalize(ctx, im)
+	}
+	im, err := c.ensureFinalizer(im)
+	if err != nil {
+		return err
+	}
 	if err := c.reconcileImmutableMap(ctx, im); err != nil {
 		return err
 	}
+	if err := c.backfillSnapshots(im); err != nil {
+		return err
+	}
+	if err := c.reconcileStatus(ctx, im); err != nil {
+		return err
+	}
 	return nil
 }
 
+// reconcileStatus reports the consumers of the MutableMap's snapshots in
+// its status, along with how far each lags behind the MutableMap.
+func (c *Reconciler) reconcileStatus(ctx context.Context, mm *v1alpha1.MutableMap) error {
+	cs, err := c.consumerLister.ListConsumers(mm)
+	if err != nil {
+		return err
+	}
+	snapshots, err := c.immutableMapLister.ImmutableMaps(mm.Namespace).ListSnapshots(mm.Name)
+	if err != nil {
+		return err
+	}
+
+	var statuses []v1alpha1.ConsumerStatus
+	// stalest indexes the consumer lagging furthest behind, in generations
+	// and then in seconds.
+	stalest := -1
+	var secondsBehind float64
+	stale := false
+	for _, consumer := range cs {
+		im, err := c.immutableMapLister.ImmutableMaps(mm.Namespace).Get(consumer.Snapshot)
+		if err != nil {
+			// Report the consumers we can rather than none of them.
+			c.Logger.Warnf("Skipping consumer %s of MutableMap %s/%s: %v", consumer.String(), mm.Namespace, mm.Name, err)
+			continue
+		}
+		status := v1alpha1.ConsumerStatus{
+			APIVersion:        consumer.APIVersion,
+			Kind:              consumer.Kind,
+			Name:              consumer.Name,
+			Snapshot:          consumer.Snapshot,
+			Generation:        im.SnapshotGeneration(),
+			GenerationsBehind: mm.Generation - im.SnapshotGeneration(),
+		}
+		for _, next := range snapshots {
+			if metav1.IsControlledBy(next, mm) && next.SnapshotGeneration() > status.Generation {
+				status.StaleSince = next.CreationTimestamp.DeepCopy()
+				break
+			}
+		}
+		var seconds float64
+		if status.StaleSince != nil {
+			stale = true
+			seconds = time.Since(status.StaleSince.Time).Seconds()
+		}
+		statuses = append(statuses, status)
+		if stalest < 0 || status.GenerationsBehind > statuses[stalest].GenerationsBehind ||
+			(status.GenerationsBehind == statuses[stalest].GenerationsBehind && seconds > secondsBehind) {
+			stalest, secondsBehind = len(statuses)-1, seconds
+		}
+	}
+	consumer, generationsBehind := "", int64(0)
+	if stalest >= 0 {
+		consumer, generationsBehind = statuses[stalest].Kind+"/"+statuses[stalest].Name, statuses[stalest].GenerationsBehind
+	}
+	if err := c.staleness.report(mm.Namespace, mm.Name, consumer, generationsBehind, secondsBehind); err != nil {
+		c.Logger.Errorf("Failed to report staleness: %v", err)
+	}
+	if stale {
+		// Keep the age of stale consumers current.
+		c.enqueueAfter(mm.Namespace+"/"+mm.Name, stalenessInterval)
+	}
+
+	if equality.Semantic.DeepEqual(mm.Status.Consumers, statuses) {
+		return nil
+	}
+	mm = mm.DeepCopy()
+	mm.Status.Consumers = statuses
+	_, err = c.boosclientset.BoosV1alpha1().MutableMaps(mm.Namespace).UpdateStatus(mm)
+	return err
+}
+
 func (c *Reconciler) reconcileImmutableMap(ctx context.Context, im *v1alpha1.MutableMap) error {
-	cmName := names.ImmutableMap(im)
+	cmName, err := c.snapshotName(im)
+	if err != nil {
+		return err
+	}
+	var values map[string][]byte
 	cm, err := c.immutableMapLister.ImmutableMaps(im.Namespace).Get(cmName)
 	if apierrs.IsNotFound(err) {
-		desiredCM := resources.MakeImmutableMap(im)
-		cm, err = c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Create(desiredCM)
-		if err != nil {
+		// Read the values first, so that we never create a snapshot that
+		// lacks its Secret, and so that its digests cover them.
+		if values, err = c.readValues(im); err != nil {
+			c.Recorder.Eventf(im, corev1.EventTypeWarning, "SnapshotFailed",
+				"Failed to read the values of ImmutableMap %q: %v", cmName, err)
 			return err
 		}
 	} else if err != nil {
 		return err
+	}
+	desiredCM, err := resources.MakeImmutableMap(im, cmName, c.filter, c.signingKey, values)
+	if err != nil {
+		c.Recorder.Eventf(im, corev1.EventTypeWarning, "SnapshotFailed",
+			"Failed to render ImmutableMap %q: %v", cmName, err)
+		return err
+	}
+	if cm == nil {
+		// Stage the Secret first, so that a snapshot never lacks the
+		// values that its digests cover.
+		if err := c.stageSecret(im, desiredCM, values); err != nil {
+			return err
+		}
+		cm, err = c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Create(desiredCM)
+		if err != nil {
+			c.Recorder.Eventf(im, corev1.EventTypeWarning, "SnapshotFailed",
+				"Failed to create ImmutableMap %q: %v", cmName, err)
+			return err
+		}
+		c.Recorder.Eventf(im, corev1.EventTypeNormal, "SnapshotCreated",
+			"Created ImmutableMap %q for generation %d", cm.Name, im.Generation)
+	} else if !metav1.IsControlledBy(cm, im) {
+		// Never update a snapshot that we do not own, e.g. one left behind
+		// by a previous incarnation of this MutableMap.
+		c.Recorder.Eventf(im, corev1.EventTypeWarning, "SnapshotConflict",
+			"ImmutableMap %q is not controlled by this MutableMap", cm.Name)
+		return fmt.Errorf("ImmutableMap %s/%s is not controlled by MutableMap %q", cm.Namespace, cm.Name, im.Name)
 	} else {
-		desiredCM := resources.MakeImmutableMap(im)
-		if !equality.Semantic.DeepEqual(cm.Spec, desiredCM.Spec) {
+		if !equality.Semantic.DeepEqual(cm.Spec, desiredCM.Spec) || !hasLabels(cm, desiredCM.Labels) {
 			cm = cm.DeepCopy()
 			cm.Spec = desiredCM.Spec
+			if cm.Labels == nil {
+				cm.Labels = make(map[string]string, len(desiredCM.Labels))
+			}
+			for k, v := range desiredCM.Labels {
+				cm.Labels[k] = v
+			}
 			cm, err = c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Update(cm)
 			if err != nil {
+				c.Recorder.Eventf(im, corev1.EventTypeWarning, "SnapshotFailed",
+					"Failed to update ImmutableMap %q: %v", cmName, err)
 				return err
 			}
+			c.Recorder.Eventf(im, corev1.EventTypeNormal, "SnapshotUpdated",
+				"Updated ImmutableMap %q", cm.Name)
+		}
+	}
+
+	return c.reconcileSecret(ctx, im, cm)
+}
+
+// snapshotName returns the name of the snapshot of the MutableMap's current
+// generation, which keeps its legacy name if it was created under one.
+func (c *Reconciler) snapshotName(mm *v1alpha1.MutableMap) (string, error) {
+	for _, legacy := range names.LegacySnapshots(mm, mm.Generation) {
+		im, err := c.immutableMapLister.ImmutableMaps(mm.Namespace).Get(legacy)
+		if err == nil && metav1.IsControlledBy(im, mm) {
+			return legacy, nil
+		} else if err != nil && !apierrs.IsNotFound(err) {
+			return "", err
+		}
+	}
+	return names.ImmutableMap(mm), nil
+}
+
+// backfillSnapshots labels and annotates the snapshots of the MutableMap
+// that predate the labels identifying their source, so that they are found
+// by ListSnapshots and tags, and records the keys of their Secrets.
+func (c *Reconciler) backfillSnapshots(mm *v1alpha1.MutableMap) error {
+	ims, err := c.immutableMapLister.ImmutableMaps(mm.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, im := range ims {
+		if !metav1.IsControlledBy(im, mm) {
+			continue
+		}
+		want := map[string]string{
+			boos.MutableMapLabelKey: v1alpha1.MutableMapLabel(mm.Name),
+			boos.ManagedByLabelKey:  boos.ManagedBy,
+		}
+		if _, ok := im.Labels[boos.GenerationLabelKey]; !ok {
+			// Snapshot names have always ended in the generation.
+			if i := strings.LastIndex(im.Name, "-"); i >= 0 {
+				if gen, err := strconv.ParseInt(im.Name[i+1:], 10, 64); err == nil {
+					want[boos.GenerationLabelKey] = strconv.FormatInt(gen, 10)
+				}
+			}
+		}
+		secretKeys := ""
+		if _, ok := im.SecretKeys(); !ok {
+			// Record the keys of Secrets created before we did.
+			if secret, err := c.secretLister.Secrets(im.Namespace).Get(im.Name); err == nil &&
+				metav1.IsControlledBy(secret, im) {
+				keys := make([]string, 0, len(secret.Data))
+				for k := range secret.Data {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				secretKeys = strings.Join(keys, ",")
+			}
+		}
+		if hasLabels(im, want) && im.Annotations[boos.MutableMapAnnotationKey] == mm.Name && secretKeys == "" {
+			continue
+		}
+		im = im.DeepCopy()
+		if im.Labels == nil {
+			im.Labels = make(map[string]string, len(want))
+		}
+		for k, v := range want {
+			im.Labels[k] = v
+		}
+		if im.Annotations == nil {
+			im.Annotations = make(map[string]string, 1)
+		}
+		im.Annotations[boos.MutableMapAnnotationKey] = mm.Name
+		if secretKeys != "" {
+			im.Annotations[boos.SecretKeysAnnotation] = secretKeys
+		}
+		if _, err := c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Update(im); err != nil {
+			c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotFailed",
+				"Failed to label ImmutableMap %q: %v", im.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// stageSecret creates the Secret of the snapshot about to be created, which
+// captures the values of its keys that are read from other resources.  The
+// MutableMap controls the Secret until the snapshot exists.
+func (c *Reconciler) stageSecret(mm *v1alpha1.MutableMap, im *v1alpha1.ImmutableMap, data map[string][]byte) error {
+	if len(mm.ValueFrom) == 0 {
+		return nil
+	}
+	desired := resources.MakeSecret(mm, im, data)
+	secret, err := c.secretLister.Secrets(im.Namespace).Get(im.Name)
+	if apierrs.IsNotFound(err) {
+		_, err = c.KubeClientSet.CoreV1().Secrets(im.Namespace).Create(desired)
+		if err != nil {
+			c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotFailed",
+				"Failed to create Secret %q: %v", im.Name, err)
+			return err
+		}
+		c.Recorder.Eventf(mm, corev1.EventTypeNormal, "SnapshotCreated",
+			"Created Secret %q for generation %d", im.Name, mm.Generation)
+		return nil
+	} else if err != nil {
+		return err
+	} else if !metav1.IsControlledBy(secret, mm) {
+		c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotConflict",
+			"Secret %q is not controlled by this MutableMap", secret.Name)
+		return fmt.Errorf("Secret %s/%s is not controlled by MutableMap %q", secret.Namespace, secret.Name, mm.Name)
+	}
+	// We staged the Secret before, but failed to create the snapshot, so
+	// nothing has read it yet and it may capture the current values.
+	if equality.Semantic.DeepEqual(secret.Data, desired.Data) &&
+		equality.Semantic.DeepEqual(secret.Annotations, desired.Annotations) {
+		return nil
+	}
+	secret = secret.DeepCopy()
+	secret.Data = desired.Data
+	secret.Annotations = desired.Annotations
+	if _, err := c.KubeClientSet.CoreV1().Secrets(im.Namespace).Update(secret); err != nil {
+		c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotFailed",
+			"Failed to update Secret %q: %v", im.Name, err)
+		return err
+	}
+	return nil
+}
+
+// reconcileSecret hands control of the Secret staged for the snapshot over
+// to it, so that they share its lifecycle.  The Secret captures the values
+// at the time the snapshot was created, which we cannot read again, so a
+// snapshot whose Secret is missing fails.
+func (c *Reconciler) reconcileSecret(ctx context.Context, mm *v1alpha1.MutableMap, im *v1alpha1.ImmutableMap) error {
+	if len(mm.ValueFrom) == 0 {
+		return nil
+	}
+	secret, err := c.secretLister.Secrets(im.Namespace).Get(im.Name)
+	if apierrs.IsNotFound(err) {
+		c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotFailed",
+			"Secret %q of generation %d does not exist, update the MutableMap to take a new snapshot", im.Name, mm.Generation)
+		return fmt.Errorf("Secret %s/%s of ImmutableMap %q does not exist", im.Namespace, im.Name, im.Name)
+	} else if err != nil {
+		return err
+	} else if metav1.IsControlledBy(secret, im) {
+		return nil
+	} else if !metav1.IsControlledBy(secret, mm) {
+		c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotConflict",
+			"Secret %q is not controlled by ImmutableMap %q", secret.Name, im.Name)
+		return fmt.Errorf("Secret %s/%s is not controlled by ImmutableMap %q", secret.Namespace, secret.Name, im.Name)
+	}
+	secret = secret.DeepCopy()
+	secret.OwnerReferences = []metav1.OwnerReference{*kmeta.NewControllerRef(im)}
+	if _, err := c.KubeClientSet.CoreV1().Secrets(im.Namespace).Update(secret); err != nil {
+		c.Recorder.Eventf(mm, corev1.EventTypeWarning, "SnapshotFailed",
+			"Failed to hand Secret %q over to its ImmutableMap: %v", im.Name, err)
+		return err
+	}
+	return nil
+}
+
+// readValues reads the current values of the MutableMap's keys that are
+// read from other resources or sealed, which are nil if it has none.
+func (c *Reconciler) readValues(mm *v1alpha1.MutableMap) (map[string][]byte, error) {
+	if len(mm.ValueFrom) == 0 {
+		return nil, nil
+	}
+	data := make(map[string][]byte, len(mm.ValueFrom))
+	for key, source := range mm.ValueFrom {
+		switch {
+		case source.SecretKeyRef != nil:
+			ref := source.SecretKeyRef
+			// We only cache the Secrets of our snapshots, so read the
+			// Secrets that values come from directly.
+			secret, err := c.KubeClientSet.CoreV1().Secrets(mm.Namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch Secret %q for key %q: %v", ref.Name, key, err)
+			}
+			value, ok := secret.Data[ref.Key]
+			if !ok {
+				return nil, fmt.Errorf("key %q does not exist in Secret %q", ref.Key, ref.Name)
+			}
+			data[key] = value
+		case source.Sealed != "":
+			priv, err := c.sealingKey()
+			if err != nil {
+				return nil, err
+			}
+			value, err := sealing.Open(priv, sealing.Scope{
+				Namespace:  mm.Namespace,
+				MutableMap: mm.Name,
+				Key:        key,
+			}, source.Sealed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unseal key %q: %v", key, err)
+			}
+			data[key] = value
+		}
+	}
+	return data, nil
+}
+
+// sealingKey returns the private key with which values are sealed.
+func (c *Reconciler) sealingKey() (*rsa.PrivateKey, error) {
+	secret, err := c.KubeClientSet.CoreV1().Secrets(system.Namespace()).Get(sealing.KeyName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the sealing key: %v", err)
+	}
+	return sealing.PrivateKey(secret)
+}
+
+// hasLabels returns whether the ImmutableMap carries all of the provided labels.
+func hasLabels(im *v1alpha1.ImmutableMap, want map[string]string) bool {
+	for k, v := range want {
+		if im.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureFinalizer adds our finalizer to the MutableMap, so that we may
+// enforce its deletion policy.  Garbage collection enforces
+// DeletionPolicyCascade without us, so we remove our finalizer from such
+// MutableMaps instead, sparing them a stuck deletion when the controller
+// is uninstalled first.
+func (c *Reconciler) ensureFinalizer(mm *v1alpha1.MutableMap) (*v1alpha1.MutableMap, error) {
+	finalizers := sets.NewString(mm.Finalizers...)
+	cascade := mm.GetDeletionPolicy() == v1alpha1.DeletionPolicyCascade
+	if finalizers.Has(boos.Finalizer) != cascade {
+		return mm, nil
+	}
+	if cascade {
+		mm = withoutFinalizer(mm)
+	} else {
+		mm = mm.DeepCopy()
+		mm.Finalizers = append(mm.Finalizers, boos.Finalizer)
+	}
+	return c.boosclientset.BoosV1alpha1().MutableMaps(mm.Namespace).Update(mm)
+}
+
+// withoutFinalizer returns a copy of the MutableMap without our finalizer.
+func withoutFinalizer(mm *v1alpha1.MutableMap) *v1alpha1.MutableMap {
+	original := mm.Finalizers
+	mm = mm.DeepCopy()
+	mm.Finalizers = nil
+	for _, f := range original {
+		if f != boos.Finalizer {
+			mm.Finalizers = append(mm.Finalizers, f)
+		}
+	}
+	return mm
+}
+
+// finalize enforces the deletion policy of the MutableMap being deleted,
+// and then removes our finalizer to let the deletion proceed.
+func (c *Reconciler) finalize(ctx context.Context, mm *v1alpha1.MutableMap) error {
+	finalizers := sets.NewString(mm.Finalizers...)
+	if !finalizers.Has(boos.Finalizer) {
+		return nil
+	}
+
+	switch policy := mm.GetDeletionPolicy(); policy {
+	case v1alpha1.DeletionPolicyCascade:
+		// Garbage collection deletes the snapshots.
+	case v1alpha1.DeletionPolicyOrphan:
+		if err := c.orphanSnapshots(mm); err != nil {
+			return err
 		}
+	default:
+		// Anything else is treated as DeletionPolicyBlock, which is safe.
+		consumers, err := c.consumerLister.ListConsumers(mm)
+		if err != nil {
+			return err
+		} else if len(consumers) != 0 {
+			users := make([]string, 0, len(consumers))
+			for _, consumer := range consumers {
+				users = append(users, consumer.String())
+			}
+			c.Recorder.Eventf(mm, corev1.EventTypeWarning, "DeletionBlocked",
+				"Deletion is blocked by consumers of its snapshots: %s", strings.Join(users, ", "))
+			// Returning an error requeues the MutableMap to check again.
+			return fmt.Errorf("deletion of MutableMap %s/%s is blocked by %d consumers",
+				mm.Namespace, mm.Name, len(consumers))
+		}
+	}
+
+	// Reset the staleness of the MutableMap, so it doesn't alert.
+	if err := c.staleness.forget(mm.Namespace, mm.Name); err != nil {
+		c.Logger.Errorf("Failed to report staleness: %v", err)
 	}
 
+	_, err := c.boosclientset.BoosV1alpha1().MutableMaps(mm.Namespace).Update(withoutFinalizer(mm))
+	return err
+}
+
+// orphanSnapshots removes the MutableMap's owner references from its
+// snapshots, so that they are not garbage collected along with it.
+func (c *Reconciler) orphanSnapshots(mm *v1alpha1.MutableMap) error {
+	ims, err := c.immutableMapLister.ImmutableMaps(mm.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, im := range ims {
+		if !metav1.IsControlledBy(im, mm) {
+			continue
+		}
+		im = im.DeepCopy()
+		refs := im.OwnerReferences[:0]
+		for _, ref := range im.OwnerReferences {
+			if ref.UID != mm.UID {
+				refs = append(refs, ref)
+			}
+		}
+		im.OwnerReferences = refs
+		if _, err := c.boosclientset.BoosV1alpha1().ImmutableMaps(im.Namespace).Update(im); err != nil {
+			return err
+		}
+	}
 	return nil
 }
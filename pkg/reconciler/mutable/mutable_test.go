@@ -0,0 +1,373 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutable
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/kmeta"
+	"github.com/knative/serving/pkg/reconciler"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/fake"
+	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources"
+	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources/names"
+	rtesting "github.com/mattmoor/boo-maps/pkg/reconciler/testing"
+)
+
+const testNamespace = "default"
+
+type mutableMapOption func(*v1alpha1.MutableMap)
+
+// withValueFrom reads the "password" key from the "creds" Secret.
+func withValueFrom(mm *v1alpha1.MutableMap) {
+	mm.ValueFrom = map[string]v1alpha1.ValueSource{
+		"password": {
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+				Key:                  "password",
+			},
+		},
+	}
+}
+
+func mutableMap(generation int64, opts ...mutableMapOption) *v1alpha1.MutableMap {
+	mm := &v1alpha1.MutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  testNamespace,
+			Name:       "foo",
+			UID:        types.UID("abcdef12-3456-7890"),
+			Generation: generation,
+			Finalizers: []string{boos.Finalizer},
+		},
+		Spec: map[string]string{"key": "value"},
+	}
+	for _, opt := range opts {
+		opt(mm)
+	}
+	return mm
+}
+
+// snapshot returns the snapshot of the MutableMap's current generation.
+func snapshot(mm *v1alpha1.MutableMap) *v1alpha1.ImmutableMap {
+	im, err := resources.MakeImmutableMap(mm, names.ImmutableMap(mm), annotations.Filter{}, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	im.UID = types.UID("im-uid")
+	return im
+}
+
+// sourceSecret returns the Secret that withValueFrom reads from.
+func sourceSecret(password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      "creds",
+		},
+		Data: map[string][]byte{"password": []byte(password)},
+	}
+}
+
+// snapshotSecret returns the Secret of the snapshot holding the password,
+// controlled by the provided owner.
+func snapshotSecret(mm *v1alpha1.MutableMap, owner kmeta.OwnerRefable, password string) *corev1.Secret {
+	secret := resources.MakeSecret(mm, snapshot(mm), map[string][]byte{"password": []byte(password)})
+	secret.OwnerReferences = []metav1.OwnerReference{*kmeta.NewControllerRef(owner)}
+	return secret
+}
+
+// newTestReconciler returns a Reconciler observing the provided objects,
+// along with the clients through which it manages them.
+func newTestReconciler(objects ...runtime.Object) (*Reconciler, *rtesting.KubeClient, *fake.Clientset) {
+	var kubeObjects, boosObjects []runtime.Object
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *corev1.ConfigMap, *corev1.Secret:
+			kubeObjects = append(kubeObjects, obj)
+		case *v1alpha1.MutableMap, *v1alpha1.ImmutableMap, *v1alpha1.MapTag:
+			boosObjects = append(boosObjects, obj)
+		}
+	}
+	kubeClient := rtesting.NewKubeClient(kubeObjects...)
+	boosClient := fake.NewSimpleClientset(boosObjects...)
+	informers := rtesting.NewInformers(objects...)
+	return &Reconciler{
+		Base: &reconciler.Base{
+			KubeClientSet: kubeClient,
+			Recorder:      record.NewFakeRecorder(100),
+			Logger:        zap.NewNop().Sugar(),
+		},
+		boosclientset:      boosClient,
+		consumerLister:     informers.Consumers(),
+		enqueueAfter:       func(interface{}, time.Duration) {},
+		mutableMapLister:   informers.Boos.Boos().V1alpha1().MutableMaps().Lister(),
+		immutableMapLister: informers.Boos.Boos().V1alpha1().ImmutableMaps().Lister(),
+		secretLister:       informers.Kube.Core().V1().Secrets().Lister(),
+	}, kubeClient, boosClient
+}
+
+// failCreatingSnapshots makes the creation of ImmutableMaps fail.
+func failCreatingSnapshots(client *fake.Clientset) {
+	client.PrependReactor("create", "immutablemaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("inducing failure for create immutablemaps")
+	})
+}
+
+func TestReconcileSecret(t *testing.T) {
+	mm := mutableMap(2, withValueFrom)
+	im := snapshot(mm)
+	// A Secret of the snapshot's name that someone else made.
+	foreign := sourceSecret("hunter2")
+	foreign.Name = im.Name
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		failing bool
+		wantErr bool
+		// want is the expected Secret of the snapshot, if any.
+		want *corev1.Secret
+	}{{
+		name:    "stages the Secret before the snapshot",
+		objects: []runtime.Object{sourceSecret("hunter2")},
+		failing: true,
+		wantErr: true,
+		want:    snapshotSecret(mm, mm, "hunter2"),
+	}, {
+		name:    "restages the Secret with the current values",
+		objects: []runtime.Object{sourceSecret("hunter3"), snapshotSecret(mm, mm, "hunter2")},
+		failing: true,
+		wantErr: true,
+		want:    snapshotSecret(mm, mm, "hunter3"),
+	}, {
+		name:    "hands the staged Secret over to the snapshot",
+		objects: []runtime.Object{sourceSecret("hunter3"), im, snapshotSecret(mm, mm, "hunter2")},
+		want:    snapshotSecret(mm, im, "hunter2"),
+	}, {
+		name:    "keeps the Secret of the snapshot",
+		objects: []runtime.Object{sourceSecret("hunter3"), im, snapshotSecret(mm, im, "hunter2")},
+		want:    snapshotSecret(mm, im, "hunter2"),
+	}, {
+		name:    "fails the snapshot whose Secret is missing",
+		objects: []runtime.Object{sourceSecret("hunter3"), im},
+		wantErr: true,
+	}, {
+		name:    "refuses a Secret it does not control",
+		objects: []runtime.Object{sourceSecret("hunter2"), foreign},
+		wantErr: true,
+	}, {
+		name:    "fails without the source Secret",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, kubeClient, boosClient := newTestReconciler(append(test.objects, mm)...)
+			if test.failing {
+				failCreatingSnapshots(boosClient)
+			}
+
+			if err := c.reconcileImmutableMap(context.Background(), mm); (err != nil) != test.wantErr {
+				t.Errorf("reconcileImmutableMap() = %v, wanted error: %v", err, test.wantErr)
+			}
+
+			got, err := kubeClient.CoreV1().Secrets(im.Namespace).Get(im.Name, metav1.GetOptions{})
+			if test.want == nil {
+				if err == nil && metav1.IsControlledBy(got, mm) {
+					t.Errorf("Secret = %v, wanted none", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			if diff := cmp.Diff(test.want.Data, got.Data); diff != "" {
+				t.Errorf("Secret data (-want, +got) = %v", diff)
+			}
+			if diff := cmp.Diff(test.want.OwnerReferences, got.OwnerReferences); diff != "" {
+				t.Errorf("Secret owners (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+// fakeConsumers lists the provided consumers.
+type fakeConsumers []v1alpha1.Consumer
+
+func (f fakeConsumers) ListConsumers(mm *v1alpha1.MutableMap) ([]v1alpha1.Consumer, error) {
+	return f, nil
+}
+
+func TestReconcileStatus(t *testing.T) {
+	mm := mutableMap(3)
+	gen1, gen2 := snapshot(mutableMap(1)), snapshot(mutableMap(2))
+	gen1.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	gen2.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	consumerOf := func(name string, im *v1alpha1.ImmutableMap) v1alpha1.Consumer {
+		return v1alpha1.Consumer{APIVersion: "apps/v1", Kind: "Deployment", Name: name, Snapshot: im.Name}
+	}
+
+	c, _, boosClient := newTestReconciler(mm, gen1, gen2)
+	c.consumerLister = fakeConsumers{
+		consumerOf("fresh", gen2),
+		// A consumer of a snapshot we have not observed yet.
+		consumerOf("unknown", snapshot(mutableMap(3))),
+		consumerOf("stalest", gen1),
+	}
+	if err := c.reconcileStatus(context.Background(), mm); err != nil {
+		t.Fatalf("reconcileStatus() = %v", err)
+	}
+
+	got, err := boosClient.BoosV1alpha1().MutableMaps(mm.Namespace).Get(mm.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	want := []v1alpha1.ConsumerStatus{{
+		APIVersion:        "apps/v1",
+		Kind:              "Deployment",
+		Name:              "fresh",
+		Snapshot:          gen2.Name,
+		Generation:        2,
+		GenerationsBehind: 1,
+	}, {
+		APIVersion:        "apps/v1",
+		Kind:              "Deployment",
+		Name:              "stalest",
+		Snapshot:          gen1.Name,
+		Generation:        1,
+		GenerationsBehind: 2,
+		StaleSince:        &gen2.CreationTimestamp,
+	}}
+	if diff := cmp.Diff(want, got.Status.Consumers); diff != "" {
+		t.Errorf("Consumers (-want, +got) = %v", diff)
+	}
+	if stalest, _ := c.staleness.stalest.Load(mm.Namespace + "/" + mm.Name); stalest != "Deployment/stalest" {
+		t.Errorf("stalest consumer = %v, wanted Deployment/stalest", stalest)
+	}
+}
+
+// withDeletionPolicy selects the deletion policy of the MutableMap.
+func withDeletionPolicy(policy v1alpha1.DeletionPolicy) mutableMapOption {
+	return func(mm *v1alpha1.MutableMap) {
+		mm.Annotations = map[string]string{boos.DeletionPolicyKey: string(policy)}
+	}
+}
+
+// withoutFinalizers clears the finalizers of the MutableMap.
+func withoutFinalizers(mm *v1alpha1.MutableMap) {
+	mm.Finalizers = nil
+}
+
+func TestEnsureFinalizer(t *testing.T) {
+	tests := []struct {
+		name string
+		mm   *v1alpha1.MutableMap
+		want []string
+	}{{
+		name: "adds the finalizer",
+		mm:   mutableMap(1, withoutFinalizers),
+		want: []string{boos.Finalizer},
+	}, {
+		name: "keeps the finalizer",
+		mm:   mutableMap(1, withDeletionPolicy(v1alpha1.DeletionPolicyOrphan)),
+		want: []string{boos.Finalizer},
+	}, {
+		name: "leaves the finalizer off under Cascade",
+		mm:   mutableMap(1, withoutFinalizers, withDeletionPolicy(v1alpha1.DeletionPolicyCascade)),
+	}, {
+		name: "removes the finalizer under Cascade",
+		mm: mutableMap(1, withDeletionPolicy(v1alpha1.DeletionPolicyCascade), func(mm *v1alpha1.MutableMap) {
+			mm.Finalizers = append(mm.Finalizers, "example.com/other")
+		}),
+		want: []string{"example.com/other"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, _ := newTestReconciler(test.mm)
+			got, err := c.ensureFinalizer(test.mm)
+			if err != nil {
+				t.Fatalf("ensureFinalizer() = %v", err)
+			}
+			if diff := cmp.Diff(test.want, got.Finalizers); diff != "" {
+				t.Errorf("Finalizers (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	mm := mutableMap(2)
+	named := func(name string, owner *v1alpha1.MutableMap) *v1alpha1.ImmutableMap {
+		im := snapshot(owner)
+		im.Name = name
+		return im
+	}
+	// A previous incarnation of the MutableMap, whose UID shares a prefix.
+	previous := mutableMap(2, func(mm *v1alpha1.MutableMap) { mm.UID = types.UID("abcde999-3456-7890") })
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    string
+	}{{
+		name: "new snapshot",
+		want: "foo-abcdef12-00002",
+	}, {
+		name:    "current snapshot",
+		objects: []runtime.Object{named("foo-abcdef12-00002", mm)},
+		want:    "foo-abcdef12-00002",
+	}, {
+		name:    "snapshot with a shorter prefix of the UID",
+		objects: []runtime.Object{named("foo-abcde-00002", mm)},
+		want:    "foo-abcde-00002",
+	}, {
+		name:    "snapshot without the UID",
+		objects: []runtime.Object{named("foo-00002", mm)},
+		want:    "foo-00002",
+	}, {
+		name:    "legacy snapshot of a previous incarnation",
+		objects: []runtime.Object{named("foo-abcde-00002", previous)},
+		want:    "foo-abcdef12-00002",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, _ := newTestReconciler(append(test.objects, mm)...)
+			got, err := c.snapshotName(mm)
+			if err != nil {
+				t.Fatalf("snapshotName() = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("snapshotName() = %s, wanted %s", got, test.want)
+			}
+		})
+	}
+}
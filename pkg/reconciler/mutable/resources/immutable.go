@@ -17,21 +17,95 @@ limitations under the License.
 package resources
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/knative/pkg/kmeta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/mattmoor/boo-maps/pkg/annotations"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
-	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources/names"
+	"github.com/mattmoor/boo-maps/pkg/integrity"
 )
 
-func MakeImmutableMap(im *v1alpha1.MutableMap) *v1alpha1.ImmutableMap {
-	return &v1alpha1.ImmutableMap{
+// MakeImmutableMap returns the snapshot of the MutableMap with the given
+// name, whose digests are signed with signingKey unless it is nil.  The
+// values are those of the snapshot's Secret, which its digests cover when
+// provided.
+func MakeImmutableMap(im *v1alpha1.MutableMap, name string, filter annotations.Filter, signingKey []byte, values map[string][]byte) (*v1alpha1.ImmutableMap, error) {
+	data, err := Render(im)
+	if err != nil {
+		return nil, err
+	}
+	if keys := v1alpha1.OversizedKeys(data); len(keys) != 0 {
+		return nil, fmt.Errorf("keys %v exceed the %d bytes that a ConfigMap can hold", keys, v1alpha1.MaxShardSize)
+	}
+	annotations := MakeAnnotations(im, filter)
+	digests := []string{integrity.Digest(data)}
+	if len(im.ValueFrom) != 0 {
+		keys := make([]string, 0, len(im.ValueFrom))
+		for k := range im.ValueFrom {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		annotations[boos.SecretKeysAnnotation] = strings.Join(keys, ",")
+		if values != nil {
+			digests = append(digests, integrity.DigestBytes(values))
+			annotations[boos.SecretDigestAnnotation] = digests[1]
+		}
+	}
+	annotations[boos.DigestAnnotation] = digests[0]
+	snapshot := &v1alpha1.ImmutableMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:            names.ImmutableMap(im),
+			Name:            name,
 			Namespace:       im.Namespace,
 			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(im)},
-			Annotations:     im.ObjectMeta.Annotations,
+			Labels:          MakeLabels(im),
+			Annotations:     annotations,
 		},
-		Spec: im.Spec,
+		Spec: data,
+	}
+	if signingKey != nil {
+		annotations[boos.SignatureAnnotation] = integrity.Sign(signingKey, integrity.SubjectOf(snapshot), digests...)
+	}
+	return snapshot, nil
+}
+
+// MakeAnnotations returns the annotations of the snapshot of the
+// MutableMap, which are those of the MutableMap that pass the filter plus
+// an audit trail of the change that produced it.
+func MakeAnnotations(mm *v1alpha1.MutableMap, filter annotations.Filter) map[string]string {
+	annotations := filter.Apply(mm.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string, 5)
+	}
+	annotations[boos.MutableMapAnnotationKey] = mm.Name
+	annotations[boos.SourceUIDAnnotation] = string(mm.UID)
+	annotations[boos.SourceResourceVersionAnnotation] = mm.ResourceVersion
+	if by, ok := mm.Annotations[boos.UpdaterAnnotation]; ok {
+		annotations[boos.ChangedByAnnotation] = by
+	}
+	if at, ok := mm.Annotations[boos.UpdateTimeAnnotation]; ok {
+		annotations[boos.ChangedAtAnnotation] = at
+	}
+	if cause, ok := mm.Annotations[boos.ChangeCauseAnnotation]; ok {
+		annotations[boos.ChangeCauseAnnotation] = cause
+	}
+	return annotations
+}
+
+// MakeLabels returns the labels of the snapshot of the MutableMap, which
+// are those of the MutableMap plus labels identifying its source.
+func MakeLabels(mm *v1alpha1.MutableMap) map[string]string {
+	labels := make(map[string]string, len(mm.Labels)+3)
+	for k, v := range mm.Labels {
+		labels[k] = v
 	}
+	labels[boos.MutableMapLabelKey] = v1alpha1.MutableMapLabel(mm.Name)
+	labels[boos.GenerationLabelKey] = strconv.FormatInt(mm.Generation, 10)
+	labels[boos.ManagedByLabelKey] = boos.ManagedBy
+	return labels
 }
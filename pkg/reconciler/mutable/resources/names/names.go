@@ -18,11 +18,51 @@ package names
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
 )
 
-// ImmutableMap gives the name of the next snapshot of this map.
+// ImmutableMap gives the name of the next snapshot of this map.  The name
+// includes a prefix of the map's UID, so that a map which is deleted and
+// re-created (restarting its generation) does not reuse the names of the
+// snapshots of its previous incarnation.
 func ImmutableMap(i *v1alpha1.MutableMap) string {
-	return fmt.Sprintf("%s-%05d", i.Name, i.Generation)
+	return Snapshot(i, i.Generation)
+}
+
+// Snapshot gives the name of the snapshot of the provided generation of
+// this map.
+func Snapshot(i *v1alpha1.MutableMap, generation int64) string {
+	return fmt.Sprintf("%s-%s-%05d", i.Name, incarnation(i, incarnationLength), generation)
+}
+
+// LegacySnapshots gives the names of the snapshot of the provided
+// generation of this map in earlier releases, newest first: with a shorter
+// prefix of its UID, and before names included its incarnation.  Snapshots
+// created under these names keep them.
+func LegacySnapshots(i *v1alpha1.MutableMap, generation int64) []string {
+	return []string{
+		fmt.Sprintf("%s-%s-%05d", i.Name, incarnation(i, legacyIncarnationLength), generation),
+		fmt.Sprintf("%s-%05d", i.Name, generation),
+	}
+}
+
+const (
+	// incarnationLength is the number of characters of the UID we include,
+	// which makes it unlikely for two incarnations of a map to collide.
+	incarnationLength = 8
+
+	// legacyIncarnationLength is the number of characters of the UID that
+	// earlier releases included.
+	legacyIncarnationLength = 5
+)
+
+// incarnation returns a prefix of the map's UID of up to the provided length.
+func incarnation(i *v1alpha1.MutableMap, length int) string {
+	uid := strings.Replace(string(i.UID), "-", "", -1)
+	if len(uid) > length {
+		uid = uid[:length]
+	}
+	return uid
 }
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package names
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+)
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        types.UID
+		generation int64
+		want       string
+		wantLegacy []string
+	}{{
+		name:       "full UID",
+		uid:        "a1b2c3d4-e5f6-7890-abcd-ef0123456789",
+		generation: 3,
+		want:       "foo-a1b2c3d4-00003",
+		wantLegacy: []string{"foo-a1b2c-00003", "foo-00003"},
+	}, {
+		name:       "dashes within the prefix",
+		uid:        "a1b2-c3d4-e5f6",
+		generation: 12345,
+		want:       "foo-a1b2c3d4-12345",
+		wantLegacy: []string{"foo-a1b2c-12345", "foo-12345"},
+	}, {
+		name:       "short UID",
+		uid:        "a1b2",
+		generation: 1,
+		want:       "foo-a1b2-00001",
+		wantLegacy: []string{"foo-a1b2-00001", "foo-00001"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mm := &v1alpha1.MutableMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: test.uid, Generation: test.generation},
+			}
+			if got := ImmutableMap(mm); got != test.want {
+				t.Errorf("ImmutableMap() = %s, wanted %s", got, test.want)
+			}
+			if diff := cmp.Diff(test.wantLegacy, LegacySnapshots(mm, test.generation)); diff != "" {
+				t.Errorf("LegacySnapshots (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
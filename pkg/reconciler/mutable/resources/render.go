@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+)
+
+// Render returns the data of the snapshot of the MutableMap, which is its
+// spec plus the keys it renders from its spec.
+func Render(mm *v1alpha1.MutableMap) (map[string]string, error) {
+	if len(mm.Render) == 0 {
+		return mm.Spec, nil
+	}
+	data := make(map[string]string, len(mm.Spec)+len(mm.Render))
+	for k, v := range mm.Spec {
+		data[k] = v
+	}
+	for key, format := range mm.Render {
+		rendered, err := render(mm.Spec, format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %q: %v", key, err)
+		}
+		data[key] = rendered
+	}
+	return data, nil
+}
+
+func render(spec map[string]string, format v1alpha1.RenderFormat) (string, error) {
+	switch format {
+	case v1alpha1.RenderJSON:
+		b, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case v1alpha1.RenderYAML:
+		b, err := yaml.Marshal(spec)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case v1alpha1.RenderProperties:
+		return renderLines(spec, func(k, v string) string {
+			return propertiesKey.Replace(k) + "=" + propertiesValue(v)
+		}), nil
+	case v1alpha1.RenderDotenv:
+		for k := range spec {
+			if msgs := validation.IsCIdentifier(k); len(msgs) != 0 {
+				return "", fmt.Errorf("key %q is not a valid environment variable name: %s", k, strings.Join(msgs, "; "))
+			}
+		}
+		return renderLines(spec, func(k, v string) string {
+			return k + `="` + dotenvValue.Replace(v) + `"`
+		}), nil
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// renderLines renders a line for each of the keys in sorted order.
+func renderLines(spec map[string]string, line func(k, v string) string) string {
+	keys := make([]string, 0, len(spec))
+	for k := range spec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(line(k, spec[k]))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+var (
+	propertiesKey = strings.NewReplacer(
+		`\`, `\\`, " ", `\ `, "=", `\=`, ":", `\:`, "#", `\#`, "!", `\!`,
+		"\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+	propertiesEscapes = strings.NewReplacer(
+		`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+	dotenvValue = strings.NewReplacer(
+		`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`)
+)
+
+// propertiesValue escapes the value of a property, including its leading
+// whitespace, which would otherwise be dropped.
+func propertiesValue(v string) string {
+	v = propertiesEscapes.Replace(v)
+	if strings.HasPrefix(v, " ") {
+		v = `\` + v
+	}
+	return v
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+)
+
+func TestRenderLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		format v1alpha1.RenderFormat
+		spec   map[string]string
+		want   string
+	}{{
+		name:   "properties sorts keys",
+		format: v1alpha1.RenderProperties,
+		spec:   map[string]string{"b": "2", "a": "1"},
+		want:   "a=1\nb=2\n",
+	}, {
+		name:   "properties escapes separators in keys",
+		format: v1alpha1.RenderProperties,
+		spec:   map[string]string{"a b=c:d#e!f": "v"},
+		want:   `a\ b\=c\:d\#e\!f=v` + "\n",
+	}, {
+		name:   "properties escapes control characters and backslashes",
+		format: v1alpha1.RenderProperties,
+		spec:   map[string]string{"k": "line1\nline2\r\tC:\\dir"},
+		want:   `k=line1\nline2\r\tC:\\dir` + "\n",
+	}, {
+		name:   "properties keeps leading whitespace of values",
+		format: v1alpha1.RenderProperties,
+		spec:   map[string]string{"k": "  v"},
+		want:   `k=\  v` + "\n",
+	}, {
+		name:   "dotenv quotes values",
+		format: v1alpha1.RenderDotenv,
+		spec:   map[string]string{"B": "two words", "A": ""},
+		want:   `A=""` + "\n" + `B="two words"` + "\n",
+	}, {
+		name:   "dotenv escapes quotes, expansions and newlines",
+		format: v1alpha1.RenderDotenv,
+		spec:   map[string]string{"K": "say \"hi\" to $USER\\\nbye\r"},
+		want:   `K="say \"hi\" to \$USER\\\nbye\r"` + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := render(test.spec, test.format)
+			if err != nil {
+				t.Fatalf("render() = %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("render() (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	spec := map[string]string{
+		"bool":      "true",
+		"number":    "0123",
+		"null":      "null",
+		"colon":     "key: value",
+		"comment":   "# not a comment",
+		"quotes":    `"double" and 'single'`,
+		"multiline": "line1\nline2\n",
+		"leading":   "  indented",
+		"unicode":   "héllo ☃",
+		"empty":     "",
+	}
+
+	tests := []struct {
+		format    v1alpha1.RenderFormat
+		unmarshal func([]byte, interface{}) error
+	}{{
+		format:    v1alpha1.RenderJSON,
+		unmarshal: json.Unmarshal,
+	}, {
+		format:    v1alpha1.RenderYAML,
+		unmarshal: yaml.Unmarshal,
+	}}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			rendered, err := render(spec, test.format)
+			if err != nil {
+				t.Fatalf("render() = %v", err)
+			}
+			var got map[string]string
+			if err := test.unmarshal([]byte(rendered), &got); err != nil {
+				t.Fatalf("Failed to parse %q: %v", rendered, err)
+			}
+			if diff := cmp.Diff(spec, got); diff != "" {
+				t.Errorf("Round trip (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format v1alpha1.RenderFormat
+		spec   map[string]string
+	}{{
+		name:   "dotenv key with a dash",
+		format: v1alpha1.RenderDotenv,
+		spec:   map[string]string{"my-key": "v"},
+	}, {
+		name:   "dotenv key with a dot",
+		format: v1alpha1.RenderDotenv,
+		spec:   map[string]string{"app.name": "v"},
+	}, {
+		name:   "dotenv key starting with a digit",
+		format: v1alpha1.RenderDotenv,
+		spec:   map[string]string{"1KEY": "v"},
+	}, {
+		name:   "unknown format",
+		format: "toml",
+		spec:   map[string]string{"k": "v"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := render(test.spec, test.format); err == nil {
+				t.Errorf("render() = %q, wanted error", got)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	mm := &v1alpha1.MutableMap{
+		Spec: map[string]string{"FOO": "bar"},
+		Render: map[string]v1alpha1.RenderFormat{
+			".env":           v1alpha1.RenderDotenv,
+			"app.properties": v1alpha1.RenderProperties,
+		},
+	}
+
+	got, err := Render(mm)
+	if err != nil {
+		t.Fatalf("Render() = %v", err)
+	}
+	want := map[string]string{
+		"FOO":            "bar",
+		".env":           `FOO="bar"` + "\n",
+		"app.properties": "FOO=bar\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Render() (-want +got): %s", diff)
+	}
+
+	mm.Spec["not-an-env-var"] = "baz"
+	if got, err := Render(mm); err == nil {
+		t.Errorf("Render() = %v, wanted error", got)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"github.com/knative/pkg/kmeta"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+)
+
+// MakeSecret returns the Secret holding the keys of the snapshot that the
+// MutableMap reads from other resources.  It is created before the
+// snapshot, so the MutableMap controls it until the snapshot takes control
+// of it, after which they share its lifecycle.
+func MakeSecret(mm *v1alpha1.MutableMap, im *v1alpha1.ImmutableMap, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            im.Name,
+			Namespace:       im.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(mm)},
+			Labels:          MakeLabels(mm),
+			Annotations:     im.Annotations,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutable
+
+import (
+	"context"
+	"sync"
+
+	"github.com/knative/pkg/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/mattmoor/boo-maps/pkg/reconciler/tags"
+)
+
+var (
+	generationsBehindStat = stats.Int64("consumer_generations_behind",
+		"Number of generations the snapshot pinned by the stalest consumer of a MutableMap lags behind it", stats.UnitNone)
+	secondsBehindStat = stats.Float64("consumer_seconds_behind",
+		"Seconds since the snapshot pinned by the stalest consumer of a MutableMap was superseded", "s")
+
+	mutableMapTagKey = tags.MustNewKey("mutable_map")
+	consumerTagKey   = tags.MustNewKey("consumer")
+)
+
+func init() {
+	err := view.Register(
+		&view.View{
+			Description: "Number of generations the snapshot pinned by the stalest consumer of a MutableMap lags behind it",
+			Measure:     generationsBehindStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{tags.Namespace, mutableMapTagKey, consumerTagKey},
+		},
+		&view.View{
+			Description: "Seconds since the snapshot pinned by the stalest consumer of a MutableMap was superseded",
+			Measure:     secondsBehindStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{tags.Namespace, mutableMapTagKey, consumerTagKey},
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// stalenessReporter records how far the stalest consumer of each
+// MutableMap lags behind it, in generations and in seconds, tagged by the
+// kind and name of that consumer.  Only the stalest consumer of each
+// MutableMap is reported, rather than every consumer, as workloads come
+// and go and each would leave a series behind.
+type stalenessReporter struct {
+	// stalest holds the consumer last reported for each MutableMap, keyed
+	// by namespace/name, whose series is reset once another takes its
+	// place so that it does not keep alerting.
+	stalest sync.Map
+}
+
+// report records the staleness of the named consumer, of the form
+// kind/name, which is empty when the MutableMap has no consumers.
+func (r *stalenessReporter) report(namespace, mutableMap, consumer string, generations int64, seconds float64) error {
+	key := namespace + "/" + mutableMap
+	if previous, ok := r.stalest.Load(key); ok && previous.(string) != consumer {
+		if err := recordStaleness(namespace, mutableMap, previous.(string), 0, 0); err != nil {
+			return err
+		}
+	}
+	r.stalest.Store(key, consumer)
+	return recordStaleness(namespace, mutableMap, consumer, generations, seconds)
+}
+
+// forget resets the staleness of the MutableMap, e.g. once it is deleted.
+func (r *stalenessReporter) forget(namespace, mutableMap string) error {
+	key := namespace + "/" + mutableMap
+	previous, ok := r.stalest.Load(key)
+	if !ok {
+		return nil
+	}
+	r.stalest.Delete(key)
+	return recordStaleness(namespace, mutableMap, previous.(string), 0, 0)
+}
+
+func recordStaleness(namespace, mutableMap, consumer string, generations int64, seconds float64) error {
+	ctx, err := tag.New(
+		context.Background(),
+		tag.Insert(tags.Namespace, namespace),
+		tag.Insert(mutableMapTagKey, mutableMap),
+		tag.Insert(consumerTagKey, consumer))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, generationsBehindStat.M(generations))
+	metrics.Record(ctx, secondsBehindStat.M(seconds))
+	return nil
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tags holds the metric tag keys shared by our reconcilers.
+package tags
+
+import (
+	"go.opencensus.io/tag"
+)
+
+// Namespace tags measurements with the namespace of the resource measured.
+var Namespace = MustNewKey("namespace")
+
+// MustNewKey returns the tag key of the provided name, panicking if it is
+// invalid.
+func MustNewKey(s string) tag.Key {
+	tagKey, err := tag.NewKey(s)
+	if err != nil {
+		panic(err)
+	}
+	return tagKey
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions"
+	"github.com/mattmoor/boo-maps/pkg/consumers"
+)
+
+// Informers holds informer factories whose caches tests populate directly
+// rather than by running the informers, so that listers observe exactly
+// the provided objects.
+type Informers struct {
+	Kube kubeinformers.SharedInformerFactory
+	Boos informers.SharedInformerFactory
+}
+
+// NewInformers returns Informers whose caches hold the provided objects.
+func NewInformers(objects ...runtime.Object) *Informers {
+	i := &Informers{
+		Kube: kubeinformers.NewSharedInformerFactory(nil, 0),
+		Boos: informers.NewSharedInformerFactory(nil, 0),
+	}
+	for _, obj := range objects {
+		if err := i.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+	return i
+}
+
+// Add adds the object to the cache of the informer of its kind.
+func (i *Informers) Add(obj runtime.Object) error {
+	return i.indexer(obj).Add(obj)
+}
+
+// Update replaces the object in the cache of the informer of its kind.
+func (i *Informers) Update(obj runtime.Object) error {
+	return i.indexer(obj).Update(obj)
+}
+
+func (i *Informers) indexer(obj runtime.Object) cache.Indexer {
+	var informer cache.SharedIndexInformer
+	switch obj.(type) {
+	case *v1alpha1.MutableMap:
+		informer = i.Boos.Boos().V1alpha1().MutableMaps().Informer()
+	case *v1alpha1.ImmutableMap:
+		informer = i.Boos.Boos().V1alpha1().ImmutableMaps().Informer()
+	case *v1alpha1.MapTag:
+		informer = i.Boos.Boos().V1alpha1().MapTags().Informer()
+	case *corev1.ConfigMap:
+		informer = i.Kube.Core().V1().ConfigMaps().Informer()
+	case *corev1.Secret:
+		informer = i.Kube.Core().V1().Secrets().Informer()
+	case *corev1.Pod:
+		informer = i.Kube.Core().V1().Pods().Informer()
+	case *appsv1.Deployment:
+		informer = i.Kube.Apps().V1().Deployments().Informer()
+	case *appsv1.ReplicaSet:
+		informer = i.Kube.Apps().V1().ReplicaSets().Informer()
+	case *appsv1.StatefulSet:
+		informer = i.Kube.Apps().V1().StatefulSets().Informer()
+	case *appsv1.DaemonSet:
+		informer = i.Kube.Apps().V1().DaemonSets().Informer()
+	case *batchv1.Job:
+		informer = i.Kube.Batch().V1().Jobs().Informer()
+	default:
+		panic(fmt.Sprintf("unsupported object %T", obj))
+	}
+	return informer.GetIndexer()
+}
+
+// Consumers returns a consumers.Lister over the workloads in our caches.
+func (i *Informers) Consumers() *consumers.Lister {
+	return consumers.New(
+		i.Boos.Boos().V1alpha1().ImmutableMaps(),
+		i.Kube.Apps().V1().Deployments(),
+		i.Kube.Apps().V1().ReplicaSets(),
+		i.Kube.Apps().V1().StatefulSets(),
+		i.Kube.Apps().V1().DaemonSets(),
+		i.Kube.Batch().V1().Jobs(),
+		i.Kube.Core().V1().Pods(),
+	)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testing holds the fakes shared by the tests of our reconcilers.
+package testing
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsResource = corev1.SchemeGroupVersion.WithResource("configmaps")
+	secretsResource    = corev1.SchemeGroupVersion.WithResource("secrets")
+)
+
+// KubeClient is a fake kubernetes.Interface backed by an object tracker.
+// We do not vendor the generated fake clientset, so it only implements
+// the operations on ConfigMaps and Secrets that our reconcilers perform,
+// and panics on anything else.
+type KubeClient struct {
+	kubernetes.Interface
+	clienttesting.Fake
+}
+
+// NewKubeClient returns a KubeClient that responds with the provided
+// objects.
+func NewKubeClient(objects ...runtime.Object) *KubeClient {
+	o := clienttesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := o.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+	c := &KubeClient{}
+	c.AddReactor("*", "*", clienttesting.ObjectReaction(o))
+	return c
+}
+
+// CoreV1 implements kubernetes.Interface
+func (c *KubeClient) CoreV1() typedcorev1.CoreV1Interface {
+	return &coreV1{Fake: &c.Fake}
+}
+
+type coreV1 struct {
+	typedcorev1.CoreV1Interface
+	*clienttesting.Fake
+}
+
+func (c *coreV1) ConfigMaps(namespace string) typedcorev1.ConfigMapInterface {
+	return &configMaps{Fake: c.Fake, ns: namespace}
+}
+
+func (c *coreV1) Secrets(namespace string) typedcorev1.SecretInterface {
+	return &secrets{Fake: c.Fake, ns: namespace}
+}
+
+type configMaps struct {
+	typedcorev1.ConfigMapInterface
+	*clienttesting.Fake
+	ns string
+}
+
+func (c *configMaps) Get(name string, options metav1.GetOptions) (*corev1.ConfigMap, error) {
+	obj, err := c.Invokes(clienttesting.NewGetAction(configMapsResource, c.ns, name), &corev1.ConfigMap{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.ConfigMap), err
+}
+
+func (c *configMaps) Create(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	obj, err := c.Invokes(clienttesting.NewCreateAction(configMapsResource, c.ns, cm), &corev1.ConfigMap{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.ConfigMap), err
+}
+
+func (c *configMaps) Update(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	obj, err := c.Invokes(clienttesting.NewUpdateAction(configMapsResource, c.ns, cm), &corev1.ConfigMap{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.ConfigMap), err
+}
+
+func (c *configMaps) Delete(name string, options *metav1.DeleteOptions) error {
+	_, err := c.Invokes(clienttesting.NewDeleteAction(configMapsResource, c.ns, name), &corev1.ConfigMap{})
+	return err
+}
+
+type secrets struct {
+	typedcorev1.SecretInterface
+	*clienttesting.Fake
+	ns string
+}
+
+func (c *secrets) Get(name string, options metav1.GetOptions) (*corev1.Secret, error) {
+	obj, err := c.Invokes(clienttesting.NewGetAction(secretsResource, c.ns, name), &corev1.Secret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.Secret), err
+}
+
+func (c *secrets) Create(secret *corev1.Secret) (*corev1.Secret, error) {
+	obj, err := c.Invokes(clienttesting.NewCreateAction(secretsResource, c.ns, secret), &corev1.Secret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.Secret), err
+}
+
+func (c *secrets) Update(secret *corev1.Secret) (*corev1.Secret, error) {
+	obj, err := c.Invokes(clienttesting.NewUpdateAction(secretsResource, c.ns, secret), &corev1.Secret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.Secret), err
+}
+
+func (c *secrets) Delete(name string, options *metav1.DeleteOptions) error {
+	_, err := c.Invokes(clienttesting.NewDeleteAction(secretsResource, c.ns, name), &corev1.Secret{})
+	return err
+}
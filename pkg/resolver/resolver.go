@@ -0,0 +1,424 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/knative/pkg/logging"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	clientset "github.com/mattmoor/boo-maps/pkg/client/clientset/versioned"
+	informers "github.com/mattmoor/boo-maps/pkg/client/informers/externalversions/boos/v1alpha1"
+	listers "github.com/mattmoor/boo-maps/pkg/client/listers/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/reconciler/mutable/resources/names"
+)
+
+// FailurePolicy determines how failures to resolve a reference are handled.
+type FailurePolicy string
+
+const (
+	// Fail denies admission of resources with references that cannot
+	// be resolved.
+	Fail FailurePolicy = "Fail"
+
+	// Ignore leaves references that cannot be resolved unchanged.
+	Ignore FailurePolicy = "Ignore"
+)
+
+// ParseFailurePolicy parses the provided string as a FailurePolicy.
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch fp := FailurePolicy(s); fp {
+	case Fail, Ignore:
+		return fp, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy %q, want %q or %q", s, Fail, Ignore)
+	}
+}
+
+const (
+	// pollInterval is how often we check for a snapshot to be materialized.
+	pollInterval = 100 * time.Millisecond
+
+	// notFoundTTL is how long we remember that a name confirmed by the API
+	// server is not a MutableMap.
+	notFoundTTL = 5 * time.Second
+
+	// notFoundSize bounds the number of names we remember.
+	notFoundSize = 1024
+)
+
+// Resolver resolves references to MutableMaps to the snapshot of their
+// current generation, and references to their tags (<mutableMap>@<tag>)
+// to the snapshot of the generation the tag points to.  References are
+// only ever resolved to snapshots whose ConfigMaps exist, so Resolve waits
+// for the controller to materialize the snapshot of a new generation until
+// the deadline of the request's context, or for snapshotTimeout when it
+// has none.
+type Resolver struct {
+	client             clientset.Interface
+	mutableMapLister   listers.MutableMapLister
+	immutableMapLister listers.ImmutableMapLister
+	mapTagLister       listers.MapTagLister
+	// configMapLister and secretLister observe the ConfigMaps and Secrets
+	// that we manage.
+	configMapLister corev1listers.ConfigMapLister
+	secretLister    corev1listers.SecretLister
+
+	failurePolicy   FailurePolicy
+	snapshotTimeout time.Duration
+
+	// notFound caches the names that the API server has confirmed are not
+	// MutableMaps or ImmutableMaps, keyed by kind/namespace/name.
+	notFound *cache.LRUExpireCache
+}
+
+// New returns a Resolver backed by the provided informers.
+func New(
+	client clientset.Interface,
+	mutableMapInformer informers.MutableMapInformer,
+	immutableMapInformer informers.ImmutableMapInformer,
+	mapTagInformer informers.MapTagInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	secretInformer corev1informers.SecretInformer,
+	failurePolicy FailurePolicy,
+	snapshotTimeout time.Duration,
+) *Resolver {
+	return &Resolver{
+		client:             client,
+		mutableMapLister:   mutableMapInformer.Lister(),
+		immutableMapLister: immutableMapInformer.Lister(),
+		mapTagLister:       mapTagInformer.Lister(),
+		configMapLister:    configMapInformer.Lister(),
+		secretLister:       secretInformer.Lister(),
+		failurePolicy:      failurePolicy,
+		snapshotTimeout:    snapshotTimeout,
+		notFound:           cache.NewLRUExpireCache(notFoundSize),
+	}
+}
+
+// Check that we implement the v1alpha1.Resolver interface.
+var _ v1alpha1.Resolver = (*Resolver)(nil)
+
+// Resolve implements v1alpha1.Resolver
+func (r *Resolver) Resolve(ctx context.Context, namespace, name string) (string, error) {
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Asked to freeze: %s", name)
+
+	frozen, err := r.resolve(ctx, namespace, name)
+	if err != nil {
+		if r.failurePolicy == Ignore {
+			logger.Errorf("Leaving %s/%s unfrozen: %v", namespace, name, err)
+			return name, nil
+		}
+		return "", err
+	}
+	return frozen, nil
+}
+
+// ResolveSecret implements v1alpha1.Resolver
+func (r *Resolver) ResolveSecret(ctx context.Context, namespace, name string) (string, error) {
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Asked to freeze Secret: %s", name)
+
+	frozen, err := r.resolveSecret(ctx, namespace, name)
+	if err != nil {
+		if r.failurePolicy == Ignore {
+			logger.Errorf("Leaving Secret %s/%s unfrozen: %v", namespace, name, err)
+			return name, nil
+		}
+		return "", err
+	}
+	return frozen, nil
+}
+
+// Snapshot implements v1alpha1.Resolver
+func (r *Resolver) Snapshot(ctx context.Context, namespace, name string) (*v1alpha1.ImmutableMap, error) {
+	im, err := r.getSnapshot(namespace, name)
+	if apierrs.IsNotFound(err) {
+		// Not a snapshot.
+		return nil, nil
+	} else if err != nil {
+		if r.failurePolicy == Ignore {
+			logging.FromContext(ctx).Errorf("Unable to fetch snapshot %s/%s: %v", namespace, name, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch ImmutableMap %s/%s: %v", namespace, name, err)
+	}
+	return im, nil
+}
+
+// GenerationSnapshot implements v1alpha1.Resolver
+func (r *Resolver) GenerationSnapshot(ctx context.Context, namespace, name string, generation int64) (*v1alpha1.ImmutableMap, error) {
+	mm, err := r.getMutableMap(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch MutableMap %s/%s: %v", namespace, name, err)
+	} else if err := checkNotDeleted(mm); err != nil {
+		return nil, err
+	}
+	im, err := r.lookupSnapshot(mm, generation)
+	if apierrs.IsNotFound(err) {
+		return nil, fmt.Errorf("generation %d of MutableMap %q has no snapshot", generation, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch snapshot of generation %d of MutableMap %s/%s: %v", generation, namespace, name, err)
+	}
+	return im, nil
+}
+
+func (r *Resolver) resolve(ctx context.Context, namespace, name string) (string, error) {
+	if mutableMap, tag, ok := v1alpha1.ParseTagReference(name); ok {
+		im, err := r.resolveTag(ctx, namespace, mutableMap, tag)
+		if err != nil {
+			return "", err
+		}
+		return im.Name, nil
+	}
+	mm, err := r.getMutableMap(namespace, name)
+	if apierrs.IsNotFound(err) {
+		// Not a MutableMap, so leave the reference alone.
+		return name, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to fetch MutableMap %s/%s: %v", namespace, name, err)
+	} else if err := checkNotDeleted(mm); err != nil {
+		return "", err
+	}
+	return r.awaitSnapshot(ctx, mm)
+}
+
+func (r *Resolver) resolveSecret(ctx context.Context, namespace, name string) (string, error) {
+	if mutableMap, tag, ok := v1alpha1.ParseTagReference(name); ok {
+		im, err := r.resolveTag(ctx, namespace, mutableMap, tag)
+		if err != nil {
+			return "", err
+		} else if _, ok := im.SecretKeys(); !ok {
+			// Unlike a plain name, a tag can name no other Secret.
+			return "", fmt.Errorf("tag %q of MutableMap %q points to snapshot %q, which has no Secret", tag, mutableMap, im.Name)
+		}
+		return im.Name, nil
+	}
+	mm, err := r.getMutableMap(namespace, name)
+	if apierrs.IsNotFound(err) {
+		// Not a MutableMap, so leave the reference alone.
+		return name, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to fetch MutableMap %s/%s: %v", namespace, name, err)
+	} else if len(mm.ValueFrom) == 0 {
+		// The MutableMap has no Secret, so this must be some other Secret.
+		return name, nil
+	} else if err := checkNotDeleted(mm); err != nil {
+		return "", err
+	}
+	return r.awaitSnapshot(ctx, mm)
+}
+
+// checkNotDeleted refuses new references to a MutableMap being deleted,
+// whose snapshots may be garbage collected from under the pods of its
+// consumers, and whose next generation would never be snapshotted.
+func checkNotDeleted(mm *v1alpha1.MutableMap) error {
+	if mm.DeletionTimestamp != nil {
+		return fmt.Errorf("MutableMap %s/%s is being deleted", mm.Namespace, mm.Name)
+	}
+	return nil
+}
+
+// resolveTag returns the snapshot of the generation of the MutableMap to
+// which its tag points.
+func (r *Resolver) resolveTag(ctx context.Context, namespace, name, tag string) (*v1alpha1.ImmutableMap, error) {
+	mt, err := r.getMapTag(namespace, v1alpha1.TagName(name, tag))
+	if apierrs.IsNotFound(err) {
+		return nil, fmt.Errorf("MutableMap %q has no tag %q", name, tag)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch tag %q of MutableMap %s/%s: %v", tag, namespace, name, err)
+	}
+	im, err := r.GenerationSnapshot(ctx, namespace, name, mt.Spec.Generation)
+	if err != nil {
+		return nil, fmt.Errorf("tag %q of MutableMap %q: %v", tag, name, err)
+	}
+	// Snapshots whose ConfigMaps were removed for lack of references are
+	// materialized again once tagged.
+	if err := r.awaitMaterialized(ctx, im); err != nil {
+		return nil, err
+	}
+	return im, nil
+}
+
+// getMapTag fetches the named MapTag, reading through to the API server
+// when our informer has not observed it, so that a tag may be created
+// together with the resources referencing it.
+func (r *Resolver) getMapTag(namespace, name string) (*v1alpha1.MapTag, error) {
+	mt, err := r.mapTagLister.MapTags(namespace).Get(name)
+	if !apierrs.IsNotFound(err) {
+		return mt, err
+	}
+	return r.client.BoosV1alpha1().MapTags(namespace).Get(name, metav1.GetOptions{})
+}
+
+// getMutableMap fetches the named MutableMap, reading through to the API
+// server when our informer has not observed it.  This is necessary when a
+// MutableMap and the resources referencing it are created together, e.g.
+// by a single `kubectl apply`.
+func (r *Resolver) getMutableMap(namespace, name string) (*v1alpha1.MutableMap, error) {
+	mm, err := r.mutableMapLister.MutableMaps(namespace).Get(name)
+	if !apierrs.IsNotFound(err) {
+		return mm, err
+	}
+	key := "MutableMap/" + namespace + "/" + name
+	if _, ok := r.notFound.Get(key); ok {
+		return nil, err
+	}
+	mm, err = r.client.BoosV1alpha1().MutableMaps(namespace).Get(name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		r.notFound.Add(key, struct{}{}, notFoundTTL)
+	}
+	return mm, err
+}
+
+// awaitSnapshot waits for the snapshot of the latest generation of the
+// provided MutableMap to exist and be materialized, and returns its name.  Dry-run requests
+// are not made to wait, but report the snapshot a real request would get.
+// We poll our informers' caches, which observe new snapshots as soon as
+// the API server would.
+func (r *Resolver) awaitSnapshot(ctx context.Context, mm *v1alpha1.MutableMap) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var name string
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		im, err := r.lookupSnapshot(mm, mm.Generation)
+		switch {
+		case err == nil:
+			// Pods fail to start until the snapshot's ConfigMaps and
+			// Secret exist.
+			name = im.Name
+			if ready, err := r.materialized(im); err != nil || ready {
+				return ready, err
+			}
+		case apierrs.IsNotFound(err):
+			// The controller only snapshots the generations it observes,
+			// so refresh the MutableMap to avoid waiting on a generation
+			// that has already been superseded.  A MutableMap that our
+			// informer has yet to observe has no newer generation.
+			if latest, err := r.mutableMapLister.MutableMaps(mm.Namespace).Get(mm.Name); err == nil &&
+				latest.UID == mm.UID && latest.Generation > mm.Generation {
+				mm = latest
+			}
+			name = names.ImmutableMap(mm)
+		default:
+			return false, err
+		}
+		return admission.IsDryRun(ctx), nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return "", fmt.Errorf("timed out waiting for snapshot %s/%s of MutableMap %q", mm.Namespace, name, mm.Name)
+	} else if err != nil {
+		return "", fmt.Errorf("failed waiting for snapshot %s/%s of MutableMap %q: %v", mm.Namespace, name, mm.Name, err)
+	}
+	return name, nil
+}
+
+// awaitMaterialized waits for the ConfigMaps and Secret of the provided
+// snapshot to exist.  Dry-run requests are not made to wait.
+func (r *Resolver) awaitMaterialized(ctx context.Context, im *v1alpha1.ImmutableMap) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		if ready, err := r.materialized(im); err != nil || ready {
+			return ready, err
+		}
+		return admission.IsDryRun(ctx), nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out waiting for snapshot %s/%s to be materialized", im.Namespace, im.Name)
+	}
+	return err
+}
+
+// materialized returns whether the ConfigMaps of the snapshot exist, along
+// with its Secret if it has one.
+func (r *Resolver) materialized(im *v1alpha1.ImmutableMap) (bool, error) {
+	for _, name := range im.ConfigMapNames() {
+		if _, err := r.configMapLister.ConfigMaps(im.Namespace).Get(name); apierrs.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if _, ok := im.SecretKeys(); ok {
+		if _, err := r.secretLister.Secrets(im.Namespace).Get(im.Name); apierrs.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// withTimeout bounds the context by snapshotTimeout, unless it already
+// has a deadline.
+func (r *Resolver) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.snapshotTimeout)
+}
+
+// lookupSnapshot returns the snapshot of the provided generation of the
+// MutableMap from our informer's cache, under its current or legacy name.
+func (r *Resolver) lookupSnapshot(mm *v1alpha1.MutableMap, generation int64) (*v1alpha1.ImmutableMap, error) {
+	lister := r.immutableMapLister.ImmutableMaps(mm.Namespace)
+	im, err := lister.Get(names.Snapshot(mm, generation))
+	if !apierrs.IsNotFound(err) {
+		return im, err
+	}
+	for _, name := range names.LegacySnapshots(mm, generation) {
+		legacy, lerr := lister.Get(name)
+		if lerr == nil && metav1.IsControlledBy(legacy, mm) {
+			return legacy, nil
+		} else if lerr != nil && !apierrs.IsNotFound(lerr) {
+			return nil, lerr
+		}
+	}
+	return nil, err
+}
+
+// getSnapshot fetches the named ImmutableMap, consulting the API server
+// directly when our informer has not observed it.  Most names are those of
+// ordinary ConfigMaps, which we remember so that admitting their consumers
+// does not cost a read from the API server each time they are consulted.
+func (r *Resolver) getSnapshot(namespace, name string) (*v1alpha1.ImmutableMap, error) {
+	im, err := r.immutableMapLister.ImmutableMaps(namespace).Get(name)
+	if !apierrs.IsNotFound(err) {
+		return im, err
+	}
+	key := "ImmutableMap/" + namespace + "/" + name
+	if _, ok := r.notFound.Get(key); ok {
+		return nil, err
+	}
+	im, err = r.client.BoosV1alpha1().ImmutableMaps(namespace).Get(name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		r.notFound.Add(key, struct{}{}, notFoundTTL)
+	}
+	return im, err
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative/pkg/kmeta"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos"
+	"github.com/mattmoor/boo-maps/pkg/apis/boos/v1alpha1"
+	"github.com/mattmoor/boo-maps/pkg/client/clientset/versioned/fake"
+	rtesting "github.com/mattmoor/boo-maps/pkg/reconciler/testing"
+)
+
+const testNamespace = "default"
+
+type mutableMapOption func(*v1alpha1.MutableMap)
+
+// withValueFrom gives the MutableMap values read from a Secret.
+func withValueFrom(mm *v1alpha1.MutableMap) {
+	mm.ValueFrom = map[string]v1alpha1.ValueSource{
+		"password": {
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+				Key:                  "password",
+			},
+		},
+	}
+}
+
+// deleted marks the MutableMap as being deleted.
+func deleted(mm *v1alpha1.MutableMap) {
+	now := metav1.Now()
+	mm.DeletionTimestamp = &now
+}
+
+func mutableMap(generation int64, opts ...mutableMapOption) *v1alpha1.MutableMap {
+	mm := &v1alpha1.MutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  testNamespace,
+			Name:       "foo",
+			UID:        types.UID("abcdef12-3456-7890"),
+			Generation: generation,
+		},
+	}
+	for _, opt := range opts {
+		opt(mm)
+	}
+	return mm
+}
+
+// snapshot returns the named snapshot of the MutableMap, which has a
+// Secret when the MutableMap has values read from one.
+func snapshot(mm *v1alpha1.MutableMap, name string) *v1alpha1.ImmutableMap {
+	im := &v1alpha1.ImmutableMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       mm.Namespace,
+			Name:            name,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(mm)},
+		},
+	}
+	if len(mm.ValueFrom) != 0 {
+		im.Annotations = map[string]string{boos.SecretKeysAnnotation: "password"}
+	}
+	return im
+}
+
+// materialized returns the snapshot along with its ConfigMap and Secret.
+func materialized(im *v1alpha1.ImmutableMap) []runtime.Object {
+	objs := []runtime.Object{im, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: im.Namespace, Name: im.Name},
+	}}
+	if _, ok := im.SecretKeys(); ok {
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: im.Namespace, Name: im.Name},
+		})
+	}
+	return objs
+}
+
+func tag(name string, generation int64) *v1alpha1.MapTag {
+	return &v1alpha1.MapTag{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: v1alpha1.TagName("foo", name)},
+		Spec:       v1alpha1.MapTagSpec{Generation: generation},
+	}
+}
+
+// newTestResolver returns a Resolver whose informers hold the observed
+// objects, and whose client holds those as well as the unobserved ones.
+func newTestResolver(policy FailurePolicy, observed, unobserved []runtime.Object) (*Resolver, *rtesting.Informers, *fake.Clientset) {
+	var boosObjects []runtime.Object
+	for _, obj := range append(observed, unobserved...) {
+		switch obj.(type) {
+		case *v1alpha1.MutableMap, *v1alpha1.ImmutableMap, *v1alpha1.MapTag:
+			boosObjects = append(boosObjects, obj)
+		}
+	}
+	client := fake.NewSimpleClientset(boosObjects...)
+	informers := rtesting.NewInformers(observed...)
+	return New(client,
+		informers.Boos.Boos().V1alpha1().MutableMaps(),
+		informers.Boos.Boos().V1alpha1().ImmutableMaps(),
+		informers.Boos.Boos().V1alpha1().MapTags(),
+		informers.Kube.Core().V1().ConfigMaps(),
+		informers.Kube.Core().V1().Secrets(),
+		policy, 300*time.Millisecond), informers, client
+}
+
+func TestResolve(t *testing.T) {
+	mm := mutableMap(2)
+	current := snapshot(mm, "foo-abcdef12-00002")
+	first := snapshot(mutableMap(1), "foo-abcdef12-00001")
+
+	tests := []struct {
+		name       string
+		ref        string
+		policy     FailurePolicy
+		dryRun     bool
+		observed   []runtime.Object
+		unobserved []runtime.Object
+		want       string
+		// wantErr is a substring of the expected error, if any.
+		wantErr string
+	}{{
+		name: "not a MutableMap",
+		ref:  "bar",
+		want: "bar",
+	}, {
+		name:     "materialized snapshot",
+		ref:      "foo",
+		observed: append(materialized(current), mm),
+		want:     current.Name,
+	}, {
+		name:     "snapshot with a shorter prefix of the UID",
+		ref:      "foo",
+		observed: append(materialized(snapshot(mm, "foo-abcde-00002")), mm),
+		want:     "foo-abcde-00002",
+	}, {
+		name:     "snapshot without the UID",
+		ref:      "foo",
+		observed: append(materialized(snapshot(mm, "foo-00002")), mm),
+		want:     "foo-00002",
+	}, {
+		name:     "snapshot yet to be materialized",
+		ref:      "foo",
+		observed: []runtime.Object{mm, current},
+		wantErr:  `timed out waiting for snapshot default/foo-abcdef12-00002 of MutableMap "foo"`,
+	}, {
+		name:     "snapshot yet to be created",
+		ref:      "foo",
+		observed: []runtime.Object{mm},
+		wantErr:  "timed out waiting for snapshot",
+	}, {
+		name:     "dry run of a snapshot yet to be created",
+		ref:      "foo",
+		dryRun:   true,
+		observed: []runtime.Object{mm},
+		want:     current.Name,
+	}, {
+		name:     "dry run of a snapshot yet to be materialized",
+		ref:      "foo",
+		dryRun:   true,
+		observed: []runtime.Object{mm, current},
+		want:     current.Name,
+	}, {
+		name:       "MutableMap created along with its consumer",
+		ref:        "foo",
+		dryRun:     true,
+		unobserved: []runtime.Object{mm},
+		want:       current.Name,
+	}, {
+		name:     "MutableMap being deleted",
+		ref:      "foo",
+		observed: append(materialized(current), mutableMap(2, deleted)),
+		wantErr:  `MutableMap default/foo is being deleted`,
+	}, {
+		name:     "MutableMap being deleted, ignoring failures",
+		ref:      "foo",
+		policy:   Ignore,
+		observed: append(materialized(current), mutableMap(2, deleted)),
+		want:     "foo",
+	}, {
+		name:     "tag",
+		ref:      "foo@stable",
+		observed: append(append(materialized(current), materialized(first)...), mm, tag("stable", 1)),
+		want:     first.Name,
+	}, {
+		name:       "tag created along with its consumer",
+		ref:        "foo@stable",
+		observed:   append(materialized(first), mm),
+		unobserved: []runtime.Object{tag("stable", 1)},
+		want:       first.Name,
+	}, {
+		name:     "tag of a snapshot yet to be materialized",
+		ref:      "foo@stable",
+		observed: []runtime.Object{mm, first, tag("stable", 1)},
+		wantErr:  "timed out waiting for snapshot default/foo-abcdef12-00001 to be materialized",
+	}, {
+		name:     "missing tag",
+		ref:      "foo@stable",
+		observed: append(materialized(current), mm),
+		wantErr:  `MutableMap "foo" has no tag "stable"`,
+	}, {
+		name:     "tag of a generation without a snapshot",
+		ref:      "foo@stable",
+		observed: append(materialized(current), mm, tag("stable", 1)),
+		wantErr:  `generation 1 of MutableMap "foo" has no snapshot`,
+	}, {
+		name:     "tag of a MutableMap being deleted",
+		ref:      "foo@stable",
+		observed: append(materialized(first), mutableMap(2, deleted), tag("stable", 1)),
+		wantErr:  `MutableMap default/foo is being deleted`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := test.policy
+			if policy == "" {
+				policy = Fail
+			}
+			r, _, _ := newTestResolver(policy, test.observed, test.unobserved)
+			ctx := context.Background()
+			if test.dryRun {
+				ctx = admission.WithDryRun(ctx)
+			}
+
+			got, err := r.Resolve(ctx, testNamespace, test.ref)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("Resolve() = %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("Resolve() = %v, wanted error containing %q", err, test.wantErr)
+			case got != test.want:
+				t.Errorf("Resolve() = %s, wanted %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	mm := mutableMap(2, withValueFrom)
+	current := snapshot(mm, "foo-abcdef12-00002")
+	withoutSecret := snapshot(mutableMap(1), "foo-abcdef12-00001")
+
+	tests := []struct {
+		name     string
+		ref      string
+		observed []runtime.Object
+		want     string
+		wantErr  string
+	}{{
+		name:     "materialized snapshot",
+		ref:      "foo",
+		observed: append(materialized(current), mm),
+		want:     current.Name,
+	}, {
+		name:     "snapshot whose Secret is yet to be created",
+		ref:      "foo",
+		observed: []runtime.Object{mm, current, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: current.Name}}},
+		wantErr:  "timed out waiting for snapshot",
+	}, {
+		name:     "MutableMap without a Secret",
+		ref:      "foo",
+		observed: append(materialized(withoutSecret), mutableMap(1)),
+		want:     "foo",
+	}, {
+		name:     "tag",
+		ref:      "foo@stable",
+		observed: append(materialized(current), mm, tag("stable", 2)),
+		want:     current.Name,
+	}, {
+		name:     "tag of a snapshot without a Secret",
+		ref:      "foo@stable",
+		observed: append(append(materialized(current), materialized(withoutSecret)...), mm, tag("stable", 1)),
+		wantErr:  `tag "stable" of MutableMap "foo" points to snapshot "foo-abcdef12-00001", which has no Secret`,
+	}, {
+		name:     "MutableMap being deleted",
+		ref:      "foo",
+		observed: append(materialized(current), mutableMap(2, withValueFrom, deleted)),
+		wantErr:  `MutableMap default/foo is being deleted`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _, _ := newTestResolver(Fail, test.observed, nil)
+
+			got, err := r.ResolveSecret(context.Background(), testNamespace, test.ref)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("ResolveSecret() = %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("ResolveSecret() = %v, wanted error containing %q", err, test.wantErr)
+			case got != test.want:
+				t.Errorf("ResolveSecret() = %s, wanted %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAwaitSnapshot(t *testing.T) {
+	mm := mutableMap(2)
+	r, informers, _ := newTestResolver(Fail, []runtime.Object{mm}, nil)
+
+	// The MutableMap is edited again and the controller snapshots only the
+	// latest generation, which it materializes while we wait.
+	latest := mutableMap(3)
+	go func() {
+		time.Sleep(2 * pollInterval)
+		informers.Update(latest)
+		for _, obj := range materialized(snapshot(latest, "foo-abcdef12-00003")) {
+			informers.Add(obj)
+		}
+	}()
+
+	got, err := r.Resolve(context.Background(), testNamespace, "foo")
+	if err != nil {
+		t.Fatalf("Resolve() = %v", err)
+	}
+	if want := "foo-abcdef12-00003"; got != want {
+		t.Errorf("Resolve() = %s, wanted %s", got, want)
+	}
+}
+
+// gets counts the reads of the resource from the API server.
+func gets(client *fake.Clientset, resource string) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNotFoundCache(t *testing.T) {
+	r, _, client := newTestResolver(Fail, nil, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if got, err := r.Resolve(ctx, testNamespace, "bar"); err != nil || got != "bar" {
+			t.Fatalf("Resolve() = %s, %v, wanted bar", got, err)
+		}
+		if im, err := r.Snapshot(ctx, testNamespace, "bar"); err != nil || im != nil {
+			t.Fatalf("Snapshot() = %v, %v, wanted none", im, err)
+		}
+	}
+	if got := gets(client, "mutablemaps"); got != 1 {
+		t.Errorf("MutableMaps read %d times, wanted once", got)
+	}
+	if got := gets(client, "immutablemaps"); got != 1 {
+		t.Errorf("ImmutableMaps read %d times, wanted once", got)
+	}
+
+	// Names are remembered per namespace.
+	if _, err := r.Resolve(ctx, "other", "bar"); err != nil {
+		t.Fatalf("Resolve() = %v", err)
+	}
+	if got := gets(client, "mutablemaps"); got != 2 {
+		t.Errorf("MutableMaps read %d times, wanted twice", got)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sealing
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KeyName is the name of the Secret in the system namespace holding
+	// the private key, and of the ConfigMap publishing the public key.
+	KeyName = "boomap-sealing-key"
+
+	// PrivateKeyKey is the key of the Secret holding the private key.
+	PrivateKeyKey = "key.pem"
+
+	// PublicKeyKey is the key of the ConfigMap holding the public key.
+	PublicKeyKey = "pub.pem"
+)
+
+// EnsureKey generates the cluster's key pair in the provided namespace if
+// it does not exist, and (re-)publishes its public key.
+func EnsureKey(client kubernetes.Interface, namespace string) error {
+	var priv *rsa.PrivateKey
+	secret, err := client.CoreV1().Secrets(namespace).Get(KeyName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		if priv, err = GenerateKey(); err != nil {
+			return err
+		}
+		_, err = client.CoreV1().Secrets(namespace).Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      KeyName,
+				Namespace: namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				PrivateKeyKey: EncodePrivateKey(priv),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create Secret %s/%s: %v", namespace, KeyName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch Secret %s/%s: %v", namespace, KeyName, err)
+	} else if priv, err = PrivateKey(secret); err != nil {
+		return err
+	}
+
+	pub := string(EncodePublicKey(&priv.PublicKey))
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(KeyName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = client.CoreV1().ConfigMaps(namespace).Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      KeyName,
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				PublicKeyKey: pub,
+			},
+		})
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch ConfigMap %s/%s: %v", namespace, KeyName, err)
+	} else if cm.Data[PublicKeyKey] != pub {
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = make(map[string]string, 1)
+		}
+		cm.Data[PublicKeyKey] = pub
+		_, err = client.CoreV1().ConfigMaps(namespace).Update(cm)
+		return err
+	}
+	return nil
+}
+
+// PrivateKey returns the private key held by the provided Secret.
+func PrivateKey(secret *corev1.Secret) (*rsa.PrivateKey, error) {
+	b, ok := secret.Data[PrivateKeyKey]
+	if !ok {
+		return nil, fmt.Errorf("Secret %s/%s has no key %q", secret.Namespace, secret.Name, PrivateKeyKey)
+	}
+	return ParsePrivateKey(b)
+}
+
+// PublicKey returns the public key published by the provided ConfigMap.
+func PublicKey(cm *corev1.ConfigMap) (*rsa.PublicKey, error) {
+	s, ok := cm.Data[PublicKeyKey]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key %q", cm.Namespace, cm.Name, PublicKeyKey)
+	}
+	return ParsePublicKey([]byte(s))
+}
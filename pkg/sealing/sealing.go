@@ -0,0 +1,224 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sealing encrypts values of MutableMaps against the cluster's
+// public key, so that they may only be decrypted by the controller.
+//
+// A sealed value is a prefix followed by the base64 encoding of a random
+// AES-256-GCM key encrypted with RSA-OAEP (prefixed by its two byte
+// length), the GCM nonce and the encrypted value.  The Scope in which the
+// value may be used is the OAEP label, so that a sealed value cannot be
+// decrypted anywhere else.  Values carrying Prefix are scoped to a single
+// key of a MutableMap, and those carrying NamespacePrefix to a namespace.
+package sealing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Prefix identifies values sealed for a single key of a MutableMap.
+	Prefix = "sealed:v2:"
+
+	// NamespacePrefix identifies values sealed for any MutableMap in a
+	// namespace, which is how all values were sealed before v2.
+	NamespacePrefix = "sealed:v1:"
+
+	// keyBits is the size of the RSA keys we generate.
+	keyBits = 3072
+
+	// sessionKeySize is the size of the AES key sealing each value.
+	sessionKeySize = 32
+)
+
+// Scope is where a sealed value may be opened.
+type Scope struct {
+	// Namespace is the namespace of the MutableMap.
+	Namespace string
+
+	// MutableMap and Key are the MutableMap and the key of its valueFrom
+	// holding the value, which are empty when sealing a value for use
+	// anywhere in the namespace.
+	MutableMap string
+	Key        string
+}
+
+// prefix returns the prefix of values sealed for the scope.
+func (s Scope) prefix() (string, error) {
+	switch {
+	case s.Namespace == "":
+		return "", errors.New("a namespace is required")
+	case s.MutableMap == "" && s.Key == "":
+		return NamespacePrefix, nil
+	case s.MutableMap == "" || s.Key == "":
+		return "", errors.New("both or neither of a MutableMap and key are required")
+	default:
+		return Prefix, nil
+	}
+}
+
+// label returns the OAEP label binding values with the prefix to the
+// scope.  Neither names nor keys may contain a slash.
+func (s Scope) label(prefix string) []byte {
+	if prefix == NamespacePrefix {
+		return []byte(s.Namespace)
+	}
+	return []byte(s.Namespace + "/" + s.MutableMap + "/" + s.Key)
+}
+
+// Seal encrypts the value for use in the given scope.
+func Seal(pub *rsa.PublicKey, scope Scope, value []byte) (string, error) {
+	prefix, err := scope.prefix()
+	if err != nil {
+		return "", err
+	}
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
+		return "", err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, sessionKey, scope.label(prefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	out := make([]byte, 2, 2+len(wrapped)+len(nonce)+len(value)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, value, nil)
+	return prefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open decrypts the value sealed for use in the given scope, which names
+// the MutableMap and key in which the value is found.
+func Open(priv *rsa.PrivateKey, scope Scope, sealed string) ([]byte, error) {
+	prefix, wrapped, rest, err := split(sealed)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrapped, scope.label(prefix))
+	if err != nil {
+		return nil, errors.New("unable to decrypt the sealed value, which may have been sealed for another namespace, MutableMap, key or cluster")
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is truncated")
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// Validate checks that the value is well-formed, without decrypting it.
+func Validate(sealed string) error {
+	_, _, _, err := split(sealed)
+	return err
+}
+
+// split decodes the sealed value into its prefix, the encrypted session
+// key and the remainder.
+func split(sealed string) (string, []byte, []byte, error) {
+	var prefix string
+	switch {
+	case strings.HasPrefix(sealed, Prefix):
+		prefix = Prefix
+	case strings.HasPrefix(sealed, NamespacePrefix):
+		prefix = NamespacePrefix
+	default:
+		return "", nil, nil, fmt.Errorf("sealed values must start with %q or %q", Prefix, NamespacePrefix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("sealed value is not valid base64: %v", err)
+	}
+	if len(b) < 2 {
+		return "", nil, nil, errors.New("sealed value is truncated")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, nil, errors.New("sealed value is truncated")
+	}
+	return prefix, b[2 : 2+n], b[2+n:], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateKey generates a new key pair with which to seal values.
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, keyBits)
+}
+
+// EncodePrivateKey encodes the private key in PEM format.
+func EncodePrivateKey(priv *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+}
+
+// EncodePublicKey encodes the public key in PEM format.
+func EncodePublicKey(pub *rsa.PublicKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(pub),
+	})
+}
+
+// ParsePrivateKey parses a PEM encoded private key.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, errors.New("expected a PEM encoded RSA PRIVATE KEY")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// ParsePublicKey parses a PEM encoded public key.
+func ParsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "RSA PUBLIC KEY" {
+		return nil, errors.New("expected a PEM encoded RSA PUBLIC KEY")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
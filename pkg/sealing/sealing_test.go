@@ -0,0 +1,262 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sealing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+var (
+	keyScope = Scope{Namespace: "default", MutableMap: "my-config", Key: "password"}
+	nsScope  = Scope{Namespace: "default"}
+)
+
+// mangle applies the provided function to the decoded body of the sealed
+// value, keeping its prefix.
+func mangle(sealed string, f func([]byte) []byte) string {
+	prefix := sealed[:len(Prefix)]
+	b, err := base64.StdEncoding.DecodeString(sealed[len(prefix):])
+	if err != nil {
+		panic(err)
+	}
+	return prefix + base64.StdEncoding.EncodeToString(f(b))
+}
+
+func TestSealOpen(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	value := []byte("hunter2")
+
+	sealed, err := Seal(&priv.PublicKey, keyScope, value)
+	if err != nil {
+		t.Fatalf("Seal() = %v", err)
+	}
+	nsSealed, err := Seal(&priv.PublicKey, nsScope, value)
+	if err != nil {
+		t.Fatalf("Seal() = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		priv    *rsa.PrivateKey
+		scope   Scope
+		sealed  string
+		wantErr bool
+	}{{
+		name:   "round trip",
+		scope:  keyScope,
+		sealed: sealed,
+	}, {
+		name:   "namespace scope round trip",
+		scope:  Scope{Namespace: "default", MutableMap: "other-config", Key: "token"},
+		sealed: nsSealed,
+	}, {
+		name:    "wrong cluster",
+		priv:    other,
+		scope:   keyScope,
+		sealed:  sealed,
+		wantErr: true,
+	}, {
+		name:    "wrong namespace",
+		scope:   Scope{Namespace: "other", MutableMap: "my-config", Key: "password"},
+		sealed:  sealed,
+		wantErr: true,
+	}, {
+		name:    "namespace scope in the wrong namespace",
+		scope:   Scope{Namespace: "other", MutableMap: "my-config", Key: "password"},
+		sealed:  nsSealed,
+		wantErr: true,
+	}, {
+		name:    "wrong MutableMap",
+		scope:   Scope{Namespace: "default", MutableMap: "other-config", Key: "password"},
+		sealed:  sealed,
+		wantErr: true,
+	}, {
+		name:    "wrong key",
+		scope:   Scope{Namespace: "default", MutableMap: "my-config", Key: "token"},
+		sealed:  sealed,
+		wantErr: true,
+	}, {
+		name:    "widened to the namespace",
+		scope:   keyScope,
+		sealed:  NamespacePrefix + strings.TrimPrefix(sealed, Prefix),
+		wantErr: true,
+	}, {
+		name:  "tampered ciphertext",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}),
+		wantErr: true,
+	}, {
+		name:  "tampered session key",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			b[2] ^= 1
+			return b
+		}),
+		wantErr: true,
+	}, {
+		name:  "truncated ciphertext",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			return b[:len(b)-1]
+		}),
+		wantErr: true,
+	}, {
+		name:  "truncated nonce",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			return b[:2+int(binary.BigEndian.Uint16(b))+4]
+		}),
+		wantErr: true,
+	}, {
+		name:  "truncated session key",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			return b[:10]
+		}),
+		wantErr: true,
+	}, {
+		name:  "truncated length prefix",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			return b[:1]
+		}),
+		wantErr: true,
+	}, {
+		name:  "length prefix past the end",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b, uint16(len(b)))
+			return b
+		}),
+		wantErr: true,
+	}, {
+		name:  "short length prefix",
+		scope: keyScope,
+		sealed: mangle(sealed, func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b, binary.BigEndian.Uint16(b)-1)
+			return b
+		}),
+		wantErr: true,
+	}, {
+		name:    "not base64",
+		scope:   keyScope,
+		sealed:  Prefix + "!!!",
+		wantErr: true,
+	}, {
+		name:    "unknown prefix",
+		scope:   keyScope,
+		sealed:  "sealed:v0:" + strings.TrimPrefix(sealed, Prefix),
+		wantErr: true,
+	}, {
+		name:    "empty",
+		scope:   keyScope,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := priv
+			if test.priv != nil {
+				key = test.priv
+			}
+			got, err := Open(key, test.scope, test.sealed)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Open() = %q, wanted error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open() = %v", err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("Open() = %q, wanted %q", got, value)
+			}
+		})
+	}
+}
+
+func TestSealPrefix(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		scope   Scope
+		want    string
+		wantErr bool
+	}{{
+		name:  "key",
+		scope: keyScope,
+		want:  Prefix,
+	}, {
+		name:  "namespace",
+		scope: nsScope,
+		want:  NamespacePrefix,
+	}, {
+		name:    "no namespace",
+		scope:   Scope{MutableMap: "my-config", Key: "password"},
+		wantErr: true,
+	}, {
+		name:    "no key",
+		scope:   Scope{Namespace: "default", MutableMap: "my-config"},
+		wantErr: true,
+	}, {
+		name:    "no MutableMap",
+		scope:   Scope{Namespace: "default", Key: "password"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := Seal(&priv.PublicKey, test.scope, []byte("hunter2"))
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Seal() = %q, wanted error", sealed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Seal() = %v", err)
+			}
+			if !strings.HasPrefix(sealed, test.want) {
+				t.Errorf("Seal() = %q, wanted prefix %q", sealed, test.want)
+			}
+			if err := Validate(sealed); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
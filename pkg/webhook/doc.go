@@ -0,0 +1,42 @@
+/*
+Copyright 2019 Matt Moore
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook is a fork of github.com/knative/pkg/webhook as of the
+// revision we vendor (f9b249fca5cd252830e725b3ef96117da75a147e, from
+// github.com/mattmoor/pkg-1).  Of that package we reuse only what does
+// not depend on the interfaces of the resources it admits, namely
+// ControllerOptions and CreateCerts.  Its AdmissionController, GenericCRD
+// and callbacks call SetDefaults and Validate without a context, so we
+// fork the admission controller itself, because the changes below alter
+// the interfaces our types implement:
+//
+//   - SetDefaults and Validate take the context of the admission request
+//     (see Defaultable and Validatable), and SetDefaults may fail, so that
+//     resolving references may consult the API server and deny admission.
+//   - Duck-typed handlers (e.g. for resources with a PodSpec) are
+//     registered as a separate webhook, to which ControllerOptions'
+//     NamespaceSelector and ObjectSelector apply.  The objectSelector of
+//     webhooks postdates our vendored API, so we register webhooks as JSON.
+//   - Validators are registered with a ValidatingWebhookConfiguration to
+//     admit updates to and deletions of resources that we do not own.
+//   - The webhook declares itself free of side effects, and reports
+//     dry-run requests, updates' baselines and audit annotations to
+//     handlers through the context (see pkg/admission).
+//   - WithContext decorates the context of each admission request, and
+//     Timeout bounds the time spent admitting it.
+//
+// These should be upstreamed, at which point this fork may be dropped.
+package webhook
@@ -0,0 +1,826 @@
+/*
+Copyright 2017 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/knative/pkg/apis"
+	"github.com/knative/pkg/apis/duck"
+	"github.com/knative/pkg/kmp"
+	"github.com/knative/pkg/logging"
+	"github.com/knative/pkg/logging/logkey"
+	knativewebhook "github.com/knative/pkg/webhook"
+	perrors "github.com/pkg/errors"
+
+	"github.com/markbates/inflect"
+	"github.com/mattbaird/jsonpatch"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1beta1 "k8s.io/api/extensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/mattmoor/boo-maps/pkg/admission"
+)
+
+const (
+	secretServerKey  = "server-key.pem"
+	secretServerCert = "server-cert.pem"
+	secretCACert     = "ca-cert.pem"
+)
+
+var (
+	deploymentKind      = v1beta1.SchemeGroupVersion.WithKind("Deployment")
+	errMissingNewObject = errors.New("the new object may not be nil")
+)
+
+// ControllerOptions contains the configuration for the webhook, which
+// extends that of the upstream webhook.
+type ControllerOptions struct {
+	knativewebhook.ControllerOptions
+
+	// NamespaceSelector restricts the namespaces in which the webhook is
+	// consulted about duck-typed resources (e.g. those containing a PodSpec).
+	// Resources with concrete handlers are always admitted by the webhook.
+	NamespaceSelector *metav1.LabelSelector
+
+	// ObjectSelector likewise restricts the duck-typed resources about
+	// which the webhook is consulted, on API servers that support it
+	// (1.15+).  Older API servers consult the webhook regardless, so our
+	// handlers must honor the same opt-outs.
+	ObjectSelector *metav1.LabelSelector
+
+	// ValidatorObjectSelector restricts the resources about which our
+	// Validators are consulted, on API servers that support it (1.15+).
+	ValidatorObjectSelector *metav1.LabelSelector
+}
+
+// ResourceValidator admits operations on resources that we do not own
+// and must not mutate, e.g. to protect the ConfigMaps we manage.
+type ResourceValidator interface {
+	// ValidateOperation returns a non-nil error to deny the operation
+	// described by the admission request.
+	ValidateOperation(context.Context, *admissionv1beta1.AdmissionRequest) error
+}
+
+// AdmissionController implements the external admission webhook for validation of
+// pilot configuration.
+type AdmissionController struct {
+	Client   kubernetes.Interface
+	Options  ControllerOptions
+	Handlers map[schema.GroupVersionKind]GenericCRD
+	Logger   *zap.SugaredLogger
+
+	// Validators are consulted about updates to and deletions of the
+	// resources of their kind, which are registered with a separate
+	// ValidatingWebhookConfiguration.
+	Validators map[schema.GroupVersionKind]ResourceValidator
+
+	// WithContext, if specified, decorates the context of each admission
+	// request, e.g. to make dependencies available to our handlers.
+	WithContext func(context.Context) context.Context
+
+	// Timeout, if non-zero, bounds the time our handlers may spend on each
+	// admission request, as the deadline of its context.
+	Timeout time.Duration
+}
+
+// GenericCRD is the interface definition that allows us to perform the generic
+// CRD actions like deciding whether to increment generation and so forth.
+type GenericCRD interface {
+	Defaultable
+	Validatable
+	runtime.Object
+}
+
+// Defaultable is a variant of apis.Defaultable for types whose defaulting
+// depends on the admission request in progress, and which may fail.
+type Defaultable interface {
+	// SetDefaults sets the defaults for the uninitialized fields of this
+	// instance.  A non-nil error denies admission of the resource.
+	SetDefaults(context.Context) error
+}
+
+// Validatable is a variant of apis.Validatable for types whose validation
+// depends on the admission request in progress.
+type Validatable interface {
+	// Validate checks the validity of this types fields.
+	Validate(context.Context) *apis.FieldError
+}
+
+// GetAPIServerExtensionCACert gets the Kubernetes aggregate apiserver
+// client CA cert used by validator.
+//
+// NOTE: this certificate is provided kubernetes. We do not control
+// its name or location.
+func getAPIServerExtensionCACert(cl kubernetes.Interface) ([]byte, error) {
+	const name = "extension-apiserver-authentication"
+	c, err := cl.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	const caFileName = "requestheader-client-ca-file"
+	pem, ok := c.Data[caFileName]
+	if !ok {
+		return nil, fmt.Errorf("cannot find %s in ConfigMap %s: ConfigMap.Data is %#v", caFileName, name, c.Data)
+	}
+	return []byte(pem), nil
+}
+
+// MakeTLSConfig makes a TLS configuration suitable for use with the server
+func makeTLSConfig(serverCert, serverKey, caCert []byte, clientAuthType tls.ClientAuthType) (*tls.Config, error) {
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+	cert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    caCertPool,
+		ClientAuth:   clientAuthType,
+	}, nil
+}
+
+func getOrGenerateKeyCertsFromSecret(ctx context.Context, client kubernetes.Interface,
+	options *ControllerOptions) (serverKey, serverCert, caCert []byte, err error) {
+	logger := logging.FromContext(ctx)
+	secret, err := client.CoreV1().Secrets(options.Namespace).Get(options.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, nil, nil, err
+		}
+		logger.Info("Did not find existing secret, creating one")
+		newSecret, err := generateSecret(ctx, options)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		secret, err = client.CoreV1().Secrets(newSecret.Namespace).Create(newSecret)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, nil, nil, err
+		}
+		// Ok, so something else might have created, try fetching it one more time
+		secret, err = client.CoreV1().Secrets(options.Namespace).Get(options.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var ok bool
+	if serverKey, ok = secret.Data[secretServerKey]; !ok {
+		return nil, nil, nil, errors.New("server key missing")
+	}
+	if serverCert, ok = secret.Data[secretServerCert]; !ok {
+		return nil, nil, nil, errors.New("server cert missing")
+	}
+	if caCert, ok = secret.Data[secretCACert]; !ok {
+		return nil, nil, nil, errors.New("ca cert missing")
+	}
+	return serverKey, serverCert, caCert, nil
+}
+
+// validate checks whether "new" and "old" implement HasImmutableFields and checks them,
+// it then delegates validation to Validatable on "new".
+func validate(ctx context.Context, old GenericCRD, new GenericCRD) error {
+	if immutableNew, ok := new.(apis.Immutable); ok && old != nil {
+		// Copy the old object and set defaults so that we don't reject our own
+		// defaulting done earlier in the webhook.
+		old = old.DeepCopyObject().(GenericCRD)
+		if err := old.SetDefaults(ctx); err != nil {
+			return err
+		}
+
+		immutableOld, ok := old.(apis.Immutable)
+		if !ok {
+			return fmt.Errorf("unexpected type mismatch %T vs. %T", old, new)
+		}
+		if err := immutableNew.CheckImmutableFields(immutableOld); err != nil {
+			return err
+		}
+	}
+	// Can't just `return new.Validate()` because it doesn't properly nil-check.
+	if err := new.Validate(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func setAnnotations(patches duck.JSONPatch, new, old GenericCRD, ui *authenticationv1.UserInfo) (duck.JSONPatch, error) {
+	// Nowhere to set the annotations.
+	if new == nil {
+		return patches, nil
+	}
+	na, ok := new.(apis.Annotatable)
+	if !ok {
+		return patches, nil
+	}
+	var oa apis.Annotatable
+	if old != nil {
+		oa = old.(apis.Annotatable)
+	}
+	b, a := new.DeepCopyObject().(apis.Annotatable), na
+
+	a.AnnotateUserInfo(oa, ui)
+	patch, err := duck.CreatePatch(b, a)
+	if err != nil {
+		return nil, err
+	}
+	return append(patches, patch...), nil
+}
+
+// setDefaults simply leverages Defaultable to set defaults.
+func setDefaults(ctx context.Context, patches duck.JSONPatch, crd GenericCRD) (duck.JSONPatch, error) {
+	before, after := crd.DeepCopyObject(), crd
+	if err := after.SetDefaults(ctx); err != nil {
+		return nil, err
+	}
+
+	patch, err := duck.CreatePatch(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(patches, patch...), nil
+}
+
+func configureCerts(ctx context.Context, client kubernetes.Interface, options *ControllerOptions) (*tls.Config, []byte, error) {
+	var apiServerCACert []byte
+	if options.ClientAuth >= tls.VerifyClientCertIfGiven {
+		var err error
+		apiServerCACert, err = getAPIServerExtensionCACert(client)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	serverKey, serverCert, caCert, err := getOrGenerateKeyCertsFromSecret(ctx, client, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := makeTLSConfig(serverCert, serverKey, apiServerCACert, options.ClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tlsConfig, caCert, nil
+}
+
+// Run implements the admission controller run loop.
+func (ac *AdmissionController) Run(stop <-chan struct{}) error {
+	logger := ac.Logger
+	ctx := logging.WithLogger(context.TODO(), logger)
+	tlsConfig, caCert, err := configureCerts(ctx, ac.Client, &ac.Options)
+	if err != nil {
+		logger.Errorw("could not configure admission webhook certs", zap.Error(err))
+		return err
+	}
+
+	server := &http.Server{
+		Handler:   ac,
+		Addr:      fmt.Sprintf(":%v", ac.Options.Port),
+		TLSConfig: tlsConfig,
+	}
+
+	logger.Info("Found certificates for webhook...")
+	if ac.Options.RegistrationDelay != 0 {
+		logger.Infof("Delaying admission webhook registration for %v", ac.Options.RegistrationDelay)
+	}
+
+	select {
+	case <-time.After(ac.Options.RegistrationDelay):
+		cl := ac.Client.AdmissionregistrationV1beta1().RESTClient()
+		if err := ac.register(ctx, cl, caCert); err != nil {
+			logger.Errorw("failed to register webhook", zap.Error(err))
+			return err
+		}
+		logger.Info("Successfully registered webhook")
+		if len(ac.Validators) != 0 {
+			if err := ac.registerValidating(ctx, cl, caCert); err != nil {
+				logger.Errorw("failed to register validating webhook", zap.Error(err))
+				return err
+			}
+			logger.Info("Successfully registered validating webhook")
+		}
+	case <-stop:
+		return nil
+	}
+
+	serverBootstrapErrCh := make(chan struct{})
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			logger.Errorw("ListenAndServeTLS for admission webhook returned error", zap.Error(err))
+			close(serverBootstrapErrCh)
+		}
+	}()
+
+	select {
+	case <-stop:
+		return server.Close()
+	case <-serverBootstrapErrCh:
+		return errors.New("webhook server bootstrap failed")
+	}
+}
+
+// register registers the external admission webhook for the resources
+// with Handlers.
+func (ac *AdmissionController) register(ctx context.Context, client rest.Interface, caCert []byte) error {
+	failurePolicy := admissionregistrationv1beta1.Fail
+	// Our handlers only read from the API server, which makes them safe to
+	// consult for dry-run requests (e.g. kubectl apply --server-dry-run).
+	sideEffects := admissionregistrationv1beta1.SideEffectClassNone
+
+	clientConfig := ac.clientConfig(caCert)
+	webhooks := []hook{{
+		Webhook: admissionregistrationv1beta1.Webhook{
+			Name:          ac.Options.WebhookName,
+			Rules:         ac.rules(isConcrete),
+			ClientConfig:  clientConfig,
+			FailurePolicy: &failurePolicy,
+			SideEffects:   &sideEffects,
+		},
+	}}
+	// Duck-typed resources are not ours, so they are registered as a
+	// separate webhook to which we may apply a NamespaceSelector and an
+	// ObjectSelector.
+	if rules := ac.rules(isDuck); len(rules) != 0 {
+		webhooks = append(webhooks, hook{
+			Webhook: admissionregistrationv1beta1.Webhook{
+				Name:              "duck." + ac.Options.WebhookName,
+				Rules:             rules,
+				ClientConfig:      clientConfig,
+				FailurePolicy:     &failurePolicy,
+				SideEffects:       &sideEffects,
+				NamespaceSelector: ac.Options.NamespaceSelector,
+			},
+			ObjectSelector: ac.Options.ObjectSelector,
+		})
+	}
+	return ac.reconcileConfiguration(ctx, client, "MutatingWebhookConfiguration", webhooks)
+}
+
+// registerValidating registers the external admission webhook for the
+// resources with Validators.
+func (ac *AdmissionController) registerValidating(ctx context.Context, client rest.Interface, caCert []byte) error {
+	// The resources we validate are not ours, so we only fail closed when
+	// the API server restricts the webhook to the objects it selects.
+	// Otherwise we fail open rather than block every update to them
+	// cluster-wide while the webhook is down.
+	failurePolicy := admissionregistrationv1beta1.Ignore
+	if ac.Options.ValidatorObjectSelector != nil && ac.honorsObjectSelector() {
+		failurePolicy = admissionregistrationv1beta1.Fail
+	}
+	sideEffects := admissionregistrationv1beta1.SideEffectClassNone
+
+	var rules []admissionregistrationv1beta1.RuleWithOperations
+	for gvk := range ac.Validators {
+		rules = append(rules, admissionregistrationv1beta1.RuleWithOperations{
+			Operations: []admissionregistrationv1beta1.OperationType{
+				admissionregistrationv1beta1.Update,
+				admissionregistrationv1beta1.Delete,
+			},
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{gvk.Group},
+				APIVersions: []string{gvk.Version},
+				Resources:   []string{strings.ToLower(inflect.Pluralize(gvk.Kind))},
+			},
+		})
+	}
+	sortRules(rules)
+
+	return ac.reconcileConfiguration(ctx, client, "ValidatingWebhookConfiguration", []hook{{
+		Webhook: admissionregistrationv1beta1.Webhook{
+			Name:          "validation." + ac.Options.WebhookName,
+			Rules:         rules,
+			ClientConfig:  ac.clientConfig(caCert),
+			FailurePolicy: &failurePolicy,
+			SideEffects:   &sideEffects,
+		},
+		ObjectSelector: ac.Options.ValidatorObjectSelector,
+	}})
+}
+
+// honorsObjectSelector returns whether the API server honors the
+// objectSelector of webhooks (Kubernetes 1.15+), which older ones ignore.
+func (ac *AdmissionController) honorsObjectSelector() bool {
+	v, err := ac.Client.Discovery().ServerVersion()
+	if err != nil {
+		return false
+	}
+	major, err := strconv.Atoi(v.Major)
+	if err != nil {
+		return false
+	}
+	// Some providers suffix the minor version, e.g. "15+".
+	minor, err := strconv.Atoi(strings.TrimSuffix(v.Minor, "+"))
+	if err != nil {
+		return false
+	}
+	return major > 1 || (major == 1 && minor >= 15)
+}
+
+// configuration mirrors the MutatingWebhookConfigurations and
+// ValidatingWebhookConfigurations of admissionregistration.k8s.io/v1beta1,
+// which share a schema.
+type configuration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Webhooks []hook `json:"webhooks,omitempty"`
+}
+
+// hook extends the Webhook of our vendored admissionregistration API with
+// the objectSelector that Kubernetes 1.15 introduced.  API servers that
+// predate it ignore the field.
+type hook struct {
+	admissionregistrationv1beta1.Webhook `json:",inline"`
+
+	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
+}
+
+// clientConfig returns how the API server reaches our webhook.
+func (ac *AdmissionController) clientConfig(caCert []byte) admissionregistrationv1beta1.WebhookClientConfig {
+	return admissionregistrationv1beta1.WebhookClientConfig{
+		Service: &admissionregistrationv1beta1.ServiceReference{
+			Namespace: ac.Options.Namespace,
+			Name:      ac.Options.ServiceName,
+		},
+		CABundle: caCert,
+	}
+}
+
+// reconcileConfiguration creates the webhook configuration of the given
+// kind with the provided webhooks, owned by our deployment, or updates it
+// when it already exists with different webhooks.  We read and write it as
+// JSON, since our typed clients would drop the fields of webhooks that our
+// vendored API lacks.
+func (ac *AdmissionController) reconcileConfiguration(ctx context.Context, client rest.Interface, kind string, webhooks []hook) error {
+	logger := logging.FromContext(ctx)
+	resource := strings.ToLower(inflect.Pluralize(kind))
+
+	// Set the owner to our deployment.
+	deploymentRef, err := ac.deploymentRef()
+	if err != nil {
+		return err
+	}
+	want := &configuration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1beta1.SchemeGroupVersion.String(),
+			Kind:       kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ac.Options.WebhookName,
+			OwnerReferences: []metav1.OwnerReference{*deploymentRef},
+		},
+		Webhooks: webhooks,
+	}
+	body, err := json.Marshal(want)
+	if err != nil {
+		return err
+	}
+
+	// Try to create the webhook and if it already exists validate webhook rules.
+	err = client.Post().Resource(resource).Body(body).Do().Error()
+	if err == nil {
+		logger.Infof("Created a %s", kind)
+		return nil
+	} else if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create a %s: %v", kind, err)
+	}
+	logger.Infof("%s already exists", kind)
+	raw, err := client.Get().Resource(resource).Name(want.Name).Do().Raw()
+	if err != nil {
+		return fmt.Errorf("error retrieving %s: %v", kind, err)
+	}
+	got := &configuration{}
+	if err := json.Unmarshal(raw, got); err != nil {
+		return fmt.Errorf("error decoding %s: %v", kind, err)
+	}
+	if ok, err := kmp.SafeEqual(got.Webhooks, want.Webhooks); err != nil {
+		return fmt.Errorf("error diffing %s: %v", kind, err)
+	} else if ok {
+		logger.Infof("%s is already valid", kind)
+		return nil
+	}
+
+	logger.Infof("Updating %s", kind)
+	// Set the ResourceVersion as required by update.
+	want.ResourceVersion = got.ResourceVersion
+	if body, err = json.Marshal(want); err != nil {
+		return err
+	}
+	if err := client.Put().Resource(resource).Name(want.Name).Body(body).Do().Error(); err != nil {
+		return fmt.Errorf("failed to update %s: %v", kind, err)
+	}
+	return nil
+}
+
+// deploymentRef returns a controller reference to our deployment, with
+// which we own the webhook configurations we register.
+func (ac *AdmissionController) deploymentRef() (*metav1.OwnerReference, error) {
+	deployment, err := ac.Client.ExtensionsV1beta1().Deployments(ac.Options.Namespace).Get(ac.Options.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch our deployment: %v", err)
+	}
+	return metav1.NewControllerRef(deployment, deploymentKind), nil
+}
+
+func isDuck(crd GenericCRD) bool {
+	_, ok := crd.(duck.Populatable)
+	return ok
+}
+
+func isConcrete(crd GenericCRD) bool {
+	return !isDuck(crd)
+}
+
+// rules returns the sorted admission rules for the handlers matching
+// the provided predicate.
+func (ac *AdmissionController) rules(pred func(GenericCRD) bool) []admissionregistrationv1beta1.RuleWithOperations {
+	var rules []admissionregistrationv1beta1.RuleWithOperations
+	for gvk, handler := range ac.Handlers {
+		if !pred(handler) {
+			continue
+		}
+		plural := strings.ToLower(inflect.Pluralize(gvk.Kind))
+
+		rules = append(rules, admissionregistrationv1beta1.RuleWithOperations{
+			Operations: []admissionregistrationv1beta1.OperationType{
+				admissionregistrationv1beta1.Create,
+				admissionregistrationv1beta1.Update,
+			},
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{gvk.Group},
+				APIVersions: []string{gvk.Version},
+				Resources:   []string{plural},
+			},
+		})
+	}
+
+	sortRules(rules)
+	return rules
+}
+
+// sortRules sorts the rules by Group, Version, Kind so that things are
+// deterministically ordered.
+func sortRules(rules []admissionregistrationv1beta1.RuleWithOperations) {
+	sort.Slice(rules, func(i, j int) bool {
+		lhs, rhs := rules[i], rules[j]
+		if lhs.APIGroups[0] != rhs.APIGroups[0] {
+			return lhs.APIGroups[0] < rhs.APIGroups[0]
+		}
+		if lhs.APIVersions[0] != rhs.APIVersions[0] {
+			return lhs.APIVersions[0] < rhs.APIVersions[0]
+		}
+		return lhs.Resources[0] < rhs.Resources[0]
+	})
+}
+
+// ServeHTTP implements the external admission webhook for mutating
+// serving resources.
+func (ac *AdmissionController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := ac.Logger
+
+	// Verify the content type is accurate.
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		http.Error(w, "invalid Content-Type, want `application/json`", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var review admissionv1beta1.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	logger = logger.With(
+		zap.String(logkey.Kind, fmt.Sprint(review.Request.Kind)),
+		zap.String(logkey.Namespace, review.Request.Namespace),
+		zap.String(logkey.Name, review.Request.Name),
+		zap.String(logkey.Operation, fmt.Sprint(review.Request.Operation)),
+		zap.String(logkey.Resource, fmt.Sprint(review.Request.Resource)),
+		zap.String(logkey.SubResource, fmt.Sprint(review.Request.SubResource)),
+		zap.String(logkey.UserInfo, fmt.Sprint(review.Request.UserInfo)))
+	ctx := logging.WithLogger(r.Context(), logger)
+	if ac.WithContext != nil {
+		ctx = ac.WithContext(ctx)
+	}
+	if ac.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ac.Timeout)
+		defer cancel()
+	}
+	reviewResponse := ac.admit(ctx, review.Request)
+	var response admissionv1beta1.AdmissionReview
+	if reviewResponse != nil {
+		response.Response = reviewResponse
+		response.Response.UID = review.Request.UID
+	}
+
+	logger.Debugf("AdmissionReview allowed: %v", reviewResponse != nil && reviewResponse.Allowed)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, fmt.Sprintf("could encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func makeErrorStatus(reason string, args ...interface{}) *admissionv1beta1.AdmissionResponse {
+	result := apierrors.NewBadRequest(fmt.Sprintf(reason, args...)).Status()
+	return &admissionv1beta1.AdmissionResponse{
+		Result:  &result,
+		Allowed: false,
+	}
+}
+
+func (ac *AdmissionController) admit(ctx context.Context, request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	logger := logging.FromContext(ctx)
+	if request.DryRun != nil && *request.DryRun {
+		ctx = admission.WithDryRun(ctx)
+	}
+	ctx, auditAnnotations := admission.WithAuditAnnotations(ctx)
+
+	gvk := schema.GroupVersionKind{
+		Group:   request.Kind.Group,
+		Version: request.Kind.Version,
+		Kind:    request.Kind.Kind,
+	}
+	if validator, ok := ac.Validators[gvk]; ok {
+		if err := validator.ValidateOperation(ctx, request); err != nil {
+			logger.Errorw("Failed the resource validator", zap.Error(err))
+			return makeErrorStatus("validation failed: %v", err)
+		}
+		return &admissionv1beta1.AdmissionResponse{
+			Allowed:          true,
+			AuditAnnotations: auditAnnotations,
+		}
+	}
+
+	switch request.Operation {
+	case admissionv1beta1.Create, admissionv1beta1.Update:
+	default:
+		logger.Infof("Unhandled webhook operation, letting it through %v", request.Operation)
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	patchBytes, err := ac.mutate(ctx, request)
+	if err != nil {
+		return makeErrorStatus("mutation failed: %v", err)
+	}
+	logger.Debugf("Patching with %d bytes", len(patchBytes))
+
+	return &admissionv1beta1.AdmissionResponse{
+		Patch:   patchBytes,
+		Allowed: true,
+		PatchType: func() *admissionv1beta1.PatchType {
+			pt := admissionv1beta1.PatchTypeJSONPatch
+			return &pt
+		}(),
+		AuditAnnotations: auditAnnotations,
+	}
+}
+
+func (ac *AdmissionController) mutate(ctx context.Context, req *admissionv1beta1.AdmissionRequest) ([]byte, error) {
+	kind := req.Kind
+	newBytes := req.Object.Raw
+	oldBytes := req.OldObject.Raw
+	// Why, oh why are these different types...
+	gvk := schema.GroupVersionKind{
+		Group:   kind.Group,
+		Version: kind.Version,
+		Kind:    kind.Kind,
+	}
+
+	logger := logging.FromContext(ctx)
+	handler, ok := ac.Handlers[gvk]
+	if !ok {
+		logger.Errorf("Unhandled kind: %v", gvk)
+		return nil, fmt.Errorf("unhandled kind: %v", gvk)
+	}
+
+	// nil values denote absence of `old` (create) or `new` (delete) objects.
+	var oldObj, newObj GenericCRD
+
+	if len(newBytes) != 0 {
+		newObj = handler.DeepCopyObject().(GenericCRD)
+		newDecoder := json.NewDecoder(bytes.NewBuffer(newBytes))
+		if err := newDecoder.Decode(&newObj); err != nil {
+			return nil, fmt.Errorf("cannot decode incoming new object: %v", err)
+		}
+	}
+	if len(oldBytes) != 0 {
+		oldObj = handler.DeepCopyObject().(GenericCRD)
+		oldDecoder := json.NewDecoder(bytes.NewBuffer(oldBytes))
+		if err := oldDecoder.Decode(&oldObj); err != nil {
+			return nil, fmt.Errorf("cannot decode incoming old object: %v", err)
+		}
+		ctx = admission.WithinUpdate(ctx, oldObj)
+	}
+	var patches duck.JSONPatch
+
+	var err error
+	// Skip this step if the type we're dealing with is a duck type, simce it is inherently
+	// incomplete and this will patch away all of the unspecified fields.
+	if _, ok := newObj.(duck.Populatable); !ok {
+		// Add these before defaulting fields, otherwise defaulting may cause an illegal patch
+		// because it expects the round tripped through Golang fields to be present already.
+		rtp, err := roundTripPatch(newBytes, newObj)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create patch for round tripped newBytes: %v", err)
+		}
+		patches = append(patches, rtp...)
+	}
+
+	if patches, err = setDefaults(ctx, patches, newObj); err != nil {
+		logger.Errorw("Failed the resource specific defaulter", zap.Error(err))
+		// Return the error message as-is to give the defaulter callback
+		// discretion over (our portion of) the message that the user sees.
+		return nil, err
+	}
+
+	if patches, err = setAnnotations(patches, newObj, oldObj, &req.UserInfo); err != nil {
+		logger.Errorw("Failed the resource annotator", zap.Error(err))
+		return nil, perrors.Wrap(err, "error setting annotations")
+	}
+
+	// None of the validators will accept a nil value for newObj.
+	if newObj == nil {
+		return nil, errMissingNewObject
+	}
+	if err := validate(ctx, oldObj, newObj); err != nil {
+		logger.Errorw("Failed the resource specific validation", zap.Error(err))
+		// Return the error message as-is to give the validation callback
+		// discretion over (our portion of) the message that the user sees.
+		return nil, err
+	}
+	return json.Marshal(patches)
+}
+
+// roundTripPatch generates the JSONPatch that corresponds to round tripping the given bytes through
+// the Golang type (JSON -> Golang type -> JSON). Because it is not always true that
+// bytes == json.Marshal(json.Unmarshal(bytes)).
+//
+// For example, if bytes did not contain a 'spec' field and the Golang type specifies its 'spec'
+// field without omitempty, then by round tripping through the Golang type, we would have added
+// `'spec': {}`.
+func roundTripPatch(bytes []byte, unmarshalled interface{}) (duck.JSONPatch, error) {
+	if unmarshalled == nil {
+		return duck.JSONPatch{}, nil
+	}
+	marshaledBytes, err := json.Marshal(unmarshalled)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal interface: %v", err)
+	}
+	return jsonpatch.CreatePatch(bytes, marshaledBytes)
+}
+
+func generateSecret(ctx context.Context, options *ControllerOptions) (*corev1.Secret, error) {
+	serverKey, serverCert, caCert, err := knativewebhook.CreateCerts(ctx, options.ServiceName, options.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      options.SecretName,
+			Namespace: options.Namespace,
+		},
+		Data: map[string][]byte{
+			secretServerKey:  serverKey,
+			secretServerCert: serverCert,
+			secretCACert:     caCert,
+		},
+	}, nil
+}